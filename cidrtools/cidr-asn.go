@@ -0,0 +1,61 @@
+package main
+
+/*
+ * Loads an MRT TABLE_DUMP_V2 RIB dump and maps IPs, one per line on STDIN
+ * or on the command line, to the origin ASN and AS path of their longest
+ * matching prefix.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+var rib = flag.String("rib", "", "MRT RIB dump to load (optionally gzip/bzip2 compressed)")
+
+func main() {
+	flag.Parse()
+
+	if *rib == "" {
+		log.Fatal("specify -rib")
+	}
+	c, _ := cidrtable.InitCidr()
+	if err := c.LoadMrt(*rib); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(flag.Args()) > 0 {
+		for _, v := range flag.Args() {
+			lookup(c, v)
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lookup(c, line)
+		}
+	}
+}
+
+func lookup(c *cidrtable.CidrTable, s string) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: couldn't parse ip [%s]\n", s)
+		return
+	}
+	r, ok := c.Lookup(ip)
+	if !ok {
+		fmt.Printf("%s\t-\n", s)
+		return
+	}
+	route := r.Value().(cidrtable.BgpRoute)
+	fmt.Printf("%s\tAS%d\t%s\n", s, route.Origin, route.AsPath)
+}
@@ -0,0 +1,112 @@
+package main
+
+/*
+ * Reads CIDR blocks (or bare IPs), one per line, aggregates them into a
+ * CidrTable and writes the result in a firewall's native format.
+ * With -from, the files named on the command line are loaded instead.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"log"
+	"os"
+	"strings"
+)
+
+var format = flag.String("format", "", "one of: iptables ip6tables nftables ipset pf aws")
+var from = flag.String("from", "", "load files in one of: aws gcp azure rir prefix-list")
+var service = flag.String("service", "", "only load this aws/gcp/azure service")
+var region = flag.String("region", "", "only load this aws/azure region or gcp scope")
+var list = flag.String("list", "", "only load this azure service tag or prefix-list name")
+var country = flag.String("country", "", "only load this rir country code")
+var status = flag.String("status", "", "only load rir records with this status (allocated, assigned, ...)")
+var name = flag.String("name", "", "chain, set, table or security group name")
+var target = flag.String("target", "", "iptables jump target (default ACCEPT)")
+var chunk = flag.Int("chunk", 0, "max entries per set, statement or group (0 for the format's default)")
+var proto = flag.String("proto", "", "aws protocol (default all traffic)")
+var fromPort = flag.Int("from-port", 0, "aws start of port range")
+var toPort = flag.Int("to-port", 0, "aws end of port range")
+
+func main() {
+	flag.Parse()
+
+	c, _ := cidrtable.InitCidr()
+	if *from != "" {
+		load(c)
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := c.AddCidr(line); err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	o := &cidrtable.ExportOptions{
+		Name:      *name,
+		Target:    *target,
+		ChunkSize: *chunk,
+		Protocol:  *proto,
+		FromPort:  *fromPort,
+		ToPort:    *toPort,
+	}
+
+	var err error
+	switch *format {
+	case "iptables":
+		err = c.WriteIptables(os.Stdout, o)
+	case "ip6tables":
+		err = c.WriteIp6tables(os.Stdout, o)
+	case "nftables":
+		err = c.WriteNftables(os.Stdout, o)
+	case "ipset":
+		err = c.WriteIpset(os.Stdout, o)
+	case "pf":
+		err = c.WritePf(os.Stdout, o)
+	case "aws":
+		err = c.WriteAwsSecurityGroups(os.Stdout, o)
+	default:
+		err = fmt.Errorf("specify -format as one of: iptables ip6tables nftables ipset pf aws")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func load(c *cidrtable.CidrTable) {
+	var loadFn func(string, *cidrtable.ImportFilter) error
+	switch *from {
+	case "aws":
+		loadFn = c.LoadAwsIpRanges
+	case "gcp":
+		loadFn = c.LoadGcpCloud
+	case "azure":
+		loadFn = c.LoadAzureServiceTags
+	case "rir":
+		loadFn = c.LoadRirDelegated
+	case "prefix-list":
+		loadFn = c.LoadPrefixList
+	default:
+		log.Fatal("specify -from as one of: aws gcp azure rir prefix-list")
+	}
+	if len(flag.Args()) == 0 {
+		log.Fatal("specify the files to load")
+	}
+
+	f := &cidrtable.ImportFilter{Service: *service, Region: *region, Name: *list, Country: *country, Status: *status}
+	for _, path := range flag.Args() {
+		if err := loadFn(path, f); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+}
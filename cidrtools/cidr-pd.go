@@ -0,0 +1,109 @@
+package main
+
+/*
+ * Plans IPv6 prefix delegation: carves -parent into levels given by
+ * -scheme (e.g. "site:8,vlan:8" makes /56 sites and /64 VLANs under a /48),
+ * with each entry's ids encoded into its prefix bits. Entries come from
+ * -plan or STDIN, one "path [id]" per line:
+ *
+ *   nyc 1
+ *   nyc/users 0x10
+ *   nyc/voice          # 0x11, the first free id after its previous sibling
+ *
+ * -nibble widens the levels to 4-bit boundaries so every prefix has its own
+ * ip6.arpa zone. The plan is printed as a table; -out also writes the
+ * prefixes as a labelled CIDR list that LoadCidrList and cidr-server read.
+ * Addresses on the command line are looked up in the plan instead, with
+ * their ids decoded.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"log"
+	"net"
+	"os"
+)
+
+var parent = flag.String("parent", "", "delegated IPv6 prefix to carve up")
+var scheme = flag.String("scheme", "site:8,vlan:8", "levels as name:bits, outermost first")
+var nibble = flag.Bool("nibble", false, "widen levels to nibble boundaries for reverse DNS")
+var planFile = flag.String("plan", "", "entries file (default STDIN)")
+var out = flag.String("out", "", "also write the prefixes as a CIDR list to this file")
+
+func main() {
+	flag.Parse()
+
+	if *parent == "" {
+		fmt.Fprintln(os.Stderr, "specify -parent")
+		os.Exit(2)
+	}
+	_, prefix, err := net.ParseCIDR(*parent)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	levels, err := cidrtable.ParseScheme(*scheme)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	if *nibble {
+		ones, _ := prefix.Mask.Size()
+		levels = cidrtable.NibbleAlign(ones, levels)
+	}
+
+	var entries []cidrtable.DelegationEntry
+	if *planFile != "" {
+		entries, err = cidrtable.LoadDelegations(*planFile)
+	} else {
+		entries, err = cidrtable.ReadDelegations(os.Stdin)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	plan, err := cidrtable.PlanDelegation(*prefix, levels, entries)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	if *out != "" {
+		fh, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		if err := plan.WriteCidrList(fh); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		if err := fh.Close(); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+
+	if len(flag.Args()) == 0 {
+		fmt.Print(plan.String())
+		return
+	}
+	failed := 0
+	for _, v := range flag.Args() {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "ERROR: couldn't parse ip [%s]\n", v)
+			failed++
+			continue
+		}
+		d, ids, ok := plan.Find(ip)
+		if ids == nil {
+			fmt.Fprintf(os.Stderr, "ERROR: [%s] isn't in [%s]\n", v, plan.Parent.String())
+			failed++
+			continue
+		}
+		name := "-"
+		if ok {
+			name = d.Name
+		}
+		fmt.Printf("%s\t%s\t%v\n", v, name, ids)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+/*
+ * Plans variable-length subnets: reads named host requirements, one
+ * "name: hosts" per line (or comma separated), from -reqs or STDIN and
+ * allocates each the smallest block that fits within -parent, largest
+ * first. The plan is printed as a table; with -out, the allocation is also
+ * written as a CidrTable export, by default a labelled CIDR list that
+ * cidr-server and LoadCidrList read back.
+ *
+ *   cidr-plan -parent 10.20.0.0/22 -reqs site12.txt -out site12.cidrs
+ */
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+var parent = flag.String("parent", "", "block to allocate from")
+var reqs = flag.String("reqs", "", "requirements file (default STDIN)")
+var out = flag.String("out", "", "also write the allocation to this file")
+var export = flag.String("export", "list", "format for -out, one of: list iptables ip6tables nftables ipset pf aws")
+var name = flag.String("name", "", "chain, set, table or security group name for -out")
+
+func main() {
+	flag.Parse()
+
+	if *parent == "" {
+		fmt.Fprintln(os.Stderr, "specify -parent")
+		os.Exit(2)
+	}
+	if _, ok := exporters[*export]; !ok {
+		fmt.Fprintln(os.Stderr, "specify -export as one of: list iptables ip6tables nftables ipset pf aws")
+		os.Exit(2)
+	}
+	_, prefix, err := net.ParseCIDR(*parent)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	var r []cidrtable.Requirement
+	if *reqs != "" {
+		r, err = cidrtable.LoadRequirements(*reqs)
+	} else {
+		r, err = cidrtable.ReadRequirements(os.Stdin)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	plan, err := cidrtable.PlanVLSM(*prefix, r)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	fmt.Print(plan.String())
+
+	if *out != "" {
+		if err := writeExport(plan.Table(), *out); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+}
+
+/* The Write* exporters -export can pick from. */
+var exporters = map[string]func(*cidrtable.CidrTable, io.Writer, *cidrtable.ExportOptions) error{
+	"list":      (*cidrtable.CidrTable).WriteCidrList,
+	"iptables":  (*cidrtable.CidrTable).WriteIptables,
+	"ip6tables": (*cidrtable.CidrTable).WriteIp6tables,
+	"nftables":  (*cidrtable.CidrTable).WriteNftables,
+	"ipset":     (*cidrtable.CidrTable).WriteIpset,
+	"pf":        (*cidrtable.CidrTable).WritePf,
+	"aws":       (*cidrtable.CidrTable).WriteAwsSecurityGroups,
+}
+
+func writeExport(c *cidrtable.CidrTable, path string) error {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := exporters[*export](c, fh, &cidrtable.ExportOptions{Name: *name}); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
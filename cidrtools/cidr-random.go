@@ -0,0 +1,118 @@
+package main
+
+/*
+ * Prints random addresses from a set of CIDR blocks, ranges and addresses,
+ * for test inputs:
+ *
+ *   cidr-random -n 5 10.0.0.0/8
+ *   cidr-random -n 100 -seed 7 -exclude 10.0.0.0/24,10.255.0.0/16 10.0.0.0/8
+ *
+ * Inputs come from the command line, one per line on STDIN, or from a CIDR
+ * list file (-list); exclusions from -exclude or an -exclude-list file. Every
+ * address left is equally likely. The same -seed gives the same addresses;
+ * without one the seed is taken from the clock and reported on STDERR.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var count = flag.Int("n", 1, "how many addresses to print")
+var seed = flag.Int64("seed", 0, "random seed, for reproducible output (default from the clock)")
+var list = flag.String("list", "", "read blocks from this CIDR list file")
+var exclude = flag.String("exclude", "", "comma-separated blocks and ranges to leave out")
+var excludeList = flag.String("exclude-list", "", "read blocks to leave out from this CIDR list file")
+
+func main() {
+	flag.Parse()
+
+	if *count < 0 {
+		fmt.Fprintln(os.Stderr, "-n can't be negative")
+		os.Exit(2)
+	}
+
+	table, _ := cidrtable.InitCidr()
+	if *list != "" {
+		if err := table.LoadCidrList(*list, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var inputs []string
+	if len(flag.Args()) > 0 {
+		inputs = flag.Args()
+	} else if *list == "" {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			inputs = append(inputs, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := addRanges(table, inputs); err != nil {
+		log.Fatal(err)
+	}
+
+	skip, _ := cidrtable.InitCidr()
+	if *excludeList != "" {
+		if err := skip.LoadCidrList(*excludeList, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *exclude != "" {
+		if err := addRanges(skip, strings.Split(*exclude, ",")); err != nil {
+			log.Fatalf("-exclude: %s", err)
+		}
+	}
+
+	if !isFlagSet("seed") {
+		*seed = time.Now().UnixNano()
+		fmt.Fprintf(os.Stderr, "seed %d\n", *seed)
+	}
+	g, err := table.Random(skip, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for i := 0; i < *count; i++ {
+		out.WriteString(g.Next().String())
+		out.WriteByte('\n')
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+/* Adds each non-blank, non-comment input to the table. */
+func addRanges(table *cidrtable.CidrTable, inputs []string) error {
+	for _, v := range inputs {
+		line := strings.TrimSpace(v)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := cidrtable.ParseRange(line)
+		if err != nil {
+			return err
+		}
+		table.AddRange(r.Start(), r.End())
+	}
+	return nil
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,107 @@
+package main
+
+/*
+ * Converts between address ranges and CIDR blocks, one per line on STDIN or
+ * on the command line:
+ *
+ *   10.0.0.1-10.0.0.10   => 10.0.0.1/32 10.0.0.2/31 10.0.0.4/30 10.0.0.8/31 10.0.0.10/32
+ *   192.0.2.10-20        => (the same, for 192.0.2.10-192.0.2.20)
+ *   10.0.0.0/22          => 10.0.0.0-10.0.3.255
+ *
+ * By default ranges become CIDR blocks and CIDR blocks become ranges; -cidrs
+ * or -range forces one output. -merge aggregates every input first, and
+ * -size adds the number of addresses. Exits 1 if any input failed.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+)
+
+var toCidrs = flag.Bool("cidrs", false, "always print CIDR blocks")
+var toRange = flag.Bool("range", false, "always print start-end ranges")
+var merge = flag.Bool("merge", false, "aggregate all inputs, then print the result")
+var size = flag.Bool("size", false, "print the number of addresses after each range or block")
+
+func main() {
+	flag.Parse()
+
+	if *toCidrs && *toRange {
+		fmt.Fprintln(os.Stderr, "specify at most one of -cidrs and -range")
+		os.Exit(2)
+	}
+
+	var inputs []string
+	where := "line"
+	if len(flag.Args()) > 0 {
+		inputs, where = flag.Args(), "arg"
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			inputs = append(inputs, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var table *cidrtable.CidrTable
+	if *merge {
+		table, _ = cidrtable.InitCidr()
+	}
+	failed := 0
+	for n, v := range inputs {
+		line := strings.TrimSpace(v)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := cidrtable.ParseRange(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s %d: %s\n", where, n+1, err)
+			failed++
+			continue
+		}
+		if table != nil {
+			table.AddRange(r.Start(), r.End())
+			continue
+		}
+		/* A range given as start-end is wanted as CIDRs, and the other way round. */
+		cidrs := *toCidrs || (!*toRange && strings.ContainsRune(line, '-'))
+		printRange(r, cidrs)
+	}
+
+	if table != nil {
+		for _, r := range table.Ranges() {
+			printRange(r, !*toRange)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d inputs failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+func printRange(r cidrtable.IpRange, cidrs bool) {
+	if !cidrs {
+		line := fmt.Sprintf("%s-%s", r.Start(), r.End())
+		if *size {
+			line += fmt.Sprintf("\t%s", r.Size())
+		}
+		fmt.Println(line)
+		return
+	}
+	for _, cidr := range r.Cidrs() {
+		line := cidr.String()
+		if *size {
+			ones, bits := cidr.Mask.Size()
+			line += fmt.Sprintf("\t%s", new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+/*
+ * Serves IP lookups over HTTP/JSON from one or more CidrTables.
+ * Send SIGHUP (or POST /reload) to re-read the source files.
+ */
+
+import (
+	"flag"
+	"github.com/yargevad/net/cidrtable/server"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Sources stores multiple tables passed in on the command line
+type Sources []server.Source
+
+func (sources *Sources) String() string {
+	strs := make([]string, len(*sources))
+	for i, src := range *sources {
+		strs[i] = src.Name + "=" + src.Format + ":" + src.Path
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (sources *Sources) Set(val string) error {
+	src, err := server.ParseSource(val)
+	if err != nil {
+		return err
+	}
+	*sources = append(*sources, src)
+	return nil
+}
+
+func main() {
+	sources := &Sources{}
+	flag.Var(sources, "table", "table to load as name=format:path, format one of: cidr aws gcp azure rir prefix-list mrt mmdb (multiple)")
+	listen := flag.String("listen", "localhost:8080", "address to listen on")
+	flag.Parse()
+
+	if len(*sources) == 0 {
+		log.Fatal("specify at least one -table")
+	}
+	s, err := server.New(*sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.Reload(); err != nil {
+				log.Printf("ERROR: reload failed: %s", err)
+			} else {
+				log.Printf("reloaded %d tables", len(*sources))
+			}
+		}
+	}()
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, s))
+}
@@ -0,0 +1,112 @@
+package main
+
+/*
+ * Splits CIDR blocks into subnets, or finds the smallest supernet covering
+ * a list of addresses and blocks:
+ *
+ *   cidr-split -len 20 10.0.0.0/16     16 /20s
+ *   cidr-split -n 6 10.0.0.0/16        8 /19s (the next power of two)
+ *   cidr-split -supernet 10.0.0.0/24 10.0.3.7
+ *
+ * Blocks (or, with -supernet, addresses, blocks and ranges) come from the
+ * command line or one per line on STDIN. -hosts adds each subnet's usable
+ * host range and count, as the subnet calculator in convert works them out.
+ */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/yargevad/net/cidrtable"
+	"github.com/yargevad/net/ip/convert"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+var length = flag.Int("len", 0, "split into subnets of this prefix length")
+var count = flag.Int("n", 0, "split into this many equal subnets (rounded up to a power of two)")
+var supernet = flag.Bool("supernet", false, "print the smallest block covering every input")
+var hosts = flag.Bool("hosts", false, "add each subnet's first and last host and host count")
+
+func main() {
+	flag.Parse()
+
+	modes := 0
+	for _, on := range []bool{*length > 0, *count > 0, *supernet} {
+		if on {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintln(os.Stderr, "specify one of: -len, -n or -supernet")
+		os.Exit(2)
+	}
+
+	var inputs []string
+	if len(flag.Args()) > 0 {
+		inputs = flag.Args()
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				inputs = append(inputs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *supernet {
+		var ranges []cidrtable.IpRange
+		for _, v := range inputs {
+			r, err := cidrtable.ParseRange(v)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+			ranges = append(ranges, r)
+		}
+		s, err := cidrtable.Supernet(ranges)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		printSubnet(s)
+		return
+	}
+
+	failed := 0
+	for _, v := range inputs {
+		_, prefix, err := net.ParseCIDR(v)
+		var subnets []net.IPNet
+		if err == nil && *length > 0 {
+			subnets, err = cidrtable.Split(*prefix, *length)
+		} else if err == nil {
+			subnets, err = cidrtable.SplitN(*prefix, *count)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			failed++
+			continue
+		}
+		for _, s := range subnets {
+			printSubnet(s)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func printSubnet(s net.IPNet) {
+	if !*hosts {
+		fmt.Println(s.String())
+		return
+	}
+	calc, err := convert.CalcSubnet(s.String())
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	fmt.Printf("%s\t%s-%s\t%s\n", s.String(), calc.FirstHost, calc.LastHost, calc.Hosts)
+}
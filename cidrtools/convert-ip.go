@@ -2,68 +2,597 @@ package main
 
 /*
  * The idea is to accept IPs as input and convert to various types of output:
- * - decimal (32, 64)
+ * - decimal, as one integer (32 bits for IPv4, 128 for IPv6)
  * - decimal, dots between octets
+ * - octal, dots between octets
  * - hexadecimal
  * - binary
+ * - reverse DNS (PTR) names, under in-addr.arpa or ip6.arpa
+ *
+ * -to picks output formats by name (comma separated), including any
+ * registered with convert.Register. Several formats can be given at once,
+ * and -format json, csv or tsv writes one record per input with the input,
+ * its family, the parsed IP and each representation (jq: .formats.hex).
+ *
+ * -classify adds the matching IANA special-purpose registry entry (private,
+ * loopback, documentation, ...) and, for multicast, the scope.
+ *
+ * -embedded decodes the IPv4 address inside IPv4-mapped, IPv4-compatible,
+ * 6to4, Teredo, NAT64 and ISATAP addresses; -nat64 adds NAT64 prefixes to
+ * the well-known ones.
+ *
+ * -mac recovers the MAC address from an EUI-64 (SLAAC) IPv6 address. With
+ * -slaac PREFIX, the input is MAC addresses instead, each turned into its
+ * SLAAC address under the /64 before any other conversion.
+ *
+ * With -from, input in any of those formats is parsed back into an IP.
+ * With -subnet, CIDR input gets an ipcalc-style report of its network,
+ * broadcast, masks and host range instead.
+ * The -pad, -sep, -nosep, -upper, -prefix and -group flags change the layout,
+ * e.g. "-hex -prefix -sep ', '" for a C array. For IPv6, "-hex" prints all
+ * eight padded groups, "-hex -compress" the RFC 5952 form and "-embed4" the
+ * last 32 bits as dotted decimal (and IPv4 as ::ffff:a.b.c.d).
+ *
+ * -scan treats each input as free text (log lines, JSON, configs), replacing
+ * every address or CIDR block in it with its conversion and leaving the rest
+ * as it was; with -annotate, the address stays and the conversions,
+ * -classify, -embedded and -mac results follow it in brackets.
+ *
+ * Input comes from the command line or, without arguments, every non-blank
+ * line of STDIN (-stop-on-blank ends at the first blank line). Failures are
+ * reported on STDERR with their line number and don't stop the run unless
+ * -strict is given. Exits 1 if any input failed, 2 for bad flags.
  */
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/yargevad/net/ip/convert"
 	"log"
+	"net"
 	"os"
 	"strings"
 )
 
 var toBinary = flag.Bool("binary", false, "convert to binary representation")
 var toHex = flag.Bool("hex", false, "convert to hexadecimal representation")
+var toDecimal = flag.Bool("decimal", false, "convert to dotted decimal representation")
+var toInt = flag.Bool("int", false, "convert to a single unsigned integer")
+var toOctal = flag.Bool("octal", false, "convert to dotted octal representation")
+var toPtr = flag.Bool("ptr", false, "convert to a reverse DNS (PTR) name")
+var to = flag.String("to", "", "convert to formats by name, comma separated: "+strings.Join(convert.FormatNames(), " "))
+var format = flag.String("format", "", "write records as one of: json csv tsv (default plain text)")
+var classify = flag.Bool("classify", false, "report the IANA special-purpose registry entry for each address")
+var embedded = flag.Bool("embedded", false, "decode the IPv4 address embedded in IPv6 transition addresses")
+var nat64 = flag.String("nat64", "", "extra NAT64 prefixes for -embedded, comma separated")
+var toMac = flag.Bool("mac", false, "recover the MAC address from an EUI-64 IPv6 address")
+var slaac = flag.String("slaac", "", "read MAC addresses and make SLAAC addresses under this /64")
+var stopOnBlank = flag.Bool("stop-on-blank", false, "stop reading STDIN at the first blank line")
+var strict = flag.Bool("strict", false, "stop at the first input that fails")
+var scan = flag.Bool("scan", false, "find addresses in free text and convert them in place")
+var annotate = flag.Bool("annotate", false, "with -scan, keep each address and add its conversions after it")
+var subnet = flag.Bool("subnet", false, "report network, broadcast, masks and host range for CIDR input")
+var from = flag.String("from", "", "parse input from one of: auto binary hex decimal int octal ptr")
+var pad = flag.Bool("pad", false, "zero-pad each group (default on for binary and hex)")
+var sep = flag.String("sep", "", "separator between groups (default . for IPv4, : for IPv6)")
+var noSep = flag.Bool("nosep", false, "no separator between groups")
+var upper = flag.Bool("upper", false, "upper case hex digits")
+var prefix = flag.Bool("prefix", false, "prefix each group with 0b, 0x or 0 (default on for octal)")
+var group = flag.Int("group", 0, "bits per group (default 8 for IPv4, 16 for IPv6)")
+var compress = flag.Bool("compress", false, "hex IPv6 in RFC 5952 canonical form")
+var embed4 = flag.Bool("embed4", false, "hex IPv6 with the last 32 bits as dotted decimal")
+
+var fromFormats = map[string]convert.Format{
+	"auto":    convert.Auto,
+	"binary":  convert.Binary,
+	"hex":     convert.Hex,
+	"decimal": convert.Decimal,
+	"int":     convert.Integer,
+	"octal":   convert.Octal,
+	"ptr":     convert.Reverse,
+}
+
+/* The layout flags given on the command line, applied over each format's defaults. */
+var layout []*flag.Flag
+
+/* One converted input, as written by -format. */
+type record struct {
+	Line    int               `json:"line"`
+	Input   string            `json:"input"`
+	Family  string            `json:"family,omitempty"`
+	Ip      string            `json:"ip,omitempty"`
+	Formats map[string]string `json:"formats,omitempty"`
+	Class   []class           `json:"class,omitempty"`
+	Scope   string            `json:"scope,omitempty"`
+	Embed   *embed            `json:"embedded,omitempty"`
+	Mac     string            `json:"mac,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+/* The IPv4 address decoded from a transition address. */
+type embed struct {
+	Kind   string `json:"kind"`
+	Ipv4   string `json:"ipv4"`
+	Prefix string `json:"prefix,omitempty"`
+	Server string `json:"server,omitempty"`
+	Port   uint16 `json:"port,omitempty"`
+	Flags  uint16 `json:"flags,omitempty"`
+}
+
+/* NAT64 prefixes from -nat64. */
+var nat64Prefixes []*net.IPNet
+
+/* The /64 from -slaac. */
+var slaacPrefix *net.IPNet
+
+/* A special-purpose registry entry, most specific first in record.Class. */
+type class struct {
+	Prefix string `json:"prefix"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Rfc    string `json:"rfc"`
+	Global bool   `json:"global"`
+}
 
 func main() {
 	flag.Parse()
 
-	if (*toBinary == false) && (*toHex == false) {
-		log.Fatal("specify one of: binary hex")
-		os.Exit(1)
+	parseFrom, ok := fromFormats[*from]
+	if *from != "" && !ok {
+		usageError("specify -from as one of: auto binary hex decimal int octal ptr")
 	}
 
-	var convertTo int
-	if *toBinary {
-		convertTo = convert.Binary
-	} else if *toHex {
-		convertTo = convert.Hex
+	/* Output formats, in a fixed order for the flags and then as given to -to. */
+	var targets []convert.Format
+	for _, t := range []struct {
+		on bool
+		f  convert.Format
+	}{
+		{*toBinary, convert.Binary},
+		{*toHex, convert.Hex},
+		{*toDecimal, convert.Decimal},
+		{*toInt, convert.Integer},
+		{*toOctal, convert.Octal},
+		{*toPtr, convert.Reverse},
+	} {
+		if t.on {
+			targets = addTarget(targets, t.f)
+		}
+	}
+	if *to != "" {
+		for _, name := range strings.Split(*to, ",") {
+			f, err := convert.ParseFormat(strings.TrimSpace(name))
+			if err != nil || f == convert.Auto {
+				usageError("specify -to as one of: %s", strings.Join(convert.FormatNames(), " "))
+			}
+			targets = addTarget(targets, f)
+		}
 	}
 
-	/* If there is more input on the command line, process that and exit */
-	if len(flag.Args()) > 0 {
-		for _, v := range flag.Args() {
-			b, err := convert.ConvertIP(v, convertTo)
+	if len(targets) == 0 && (*from == "") && (*subnet == false) && (*classify == false) && (*embedded == false) &&
+		(*toMac == false) && (*slaac == "") && (*scan == false) {
+		usageError("specify one of: binary hex decimal int octal ptr (or -to, -from, -subnet, -classify, -embedded, -mac, -slaac)")
+	}
+
+	if *slaac != "" {
+		var err error
+		if _, slaacPrefix, err = net.ParseCIDR(*slaac); err != nil {
+			usageError("%s", err)
+		} else if ones, bits := slaacPrefix.Mask.Size(); ones != 64 || bits != 128 {
+			usageError("specify -slaac as an IPv6 /64")
+		}
+	}
+
+	if *nat64 != "" {
+		for _, v := range strings.Split(*nat64, ",") {
+			_, ipnet, err := net.ParseCIDR(strings.TrimSpace(v))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-			} else {
-				fmt.Println(b)
+				usageError("%s", err)
 			}
+			nat64Prefixes = append(nat64Prefixes, ipnet)
 		}
-		return
 	}
 
-	/* Process STDIN and exit when we get EOF or a blank line */
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		/* Blank line means we're done. */
-		if line == "" {
-			break
+	switch *format {
+	case "", "json", "csv", "tsv":
+	default:
+		usageError("specify -format as one of: json csv tsv")
+	}
+	if *subnet && *format != "" {
+		usageError("-format doesn't apply to -subnet")
+	}
+	if *scan {
+		switch {
+		case *format != "" || *subnet || *from != "" || *slaac != "":
+			usageError("-scan doesn't combine with -format, -subnet, -from or -slaac")
+		case *annotate:
+		case len(targets) > 1:
+			usageError("-scan replaces each address with one format; use -annotate for more")
+		case *classify || *embedded || *toMac:
+			usageError("-classify, -embedded and -mac need -annotate with -scan")
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pad", "sep", "nosep", "upper", "prefix", "group", "compress", "embed4":
+			layout = append(layout, f)
+		}
+	})
+
+	w := newWriter(*format, targets)
+
+	/* Inputs from the command line, or else every line of STDIN. */
+	var lines, failed int
+	if len(flag.Args()) > 0 {
+		for i, v := range flag.Args() {
+			lines++
+			if !w.write(fmt.Sprintf("arg %d", i+1), i+1, v, parseFrom, targets) {
+				failed++
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				if *stopOnBlank {
+					break
+				} else if *scan {
+					fmt.Println(scanner.Text())
+				}
+				continue
+			}
+			/* Free text goes through untouched, whitespace and all. */
+			if *scan {
+				line = scanner.Text()
+			}
+			lines++
+			if !w.write(fmt.Sprintf("line %d", n), n, line, parseFrom, targets) {
+				failed++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			w.flush()
+			log.Fatalf("ERROR: reading input: %s", err)
+		}
+	}
+	w.flush()
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d inputs failed\n", failed, lines)
+		os.Exit(1)
+	}
+}
+
+/* Exits with status 2 for bad flags, leaving 1 for inputs that failed. */
+func usageError(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	os.Exit(2)
+}
+
+func addTarget(targets []convert.Format, f convert.Format) []convert.Format {
+	for _, t := range targets {
+		if t == f {
+			return targets
+		}
+	}
+	return append(targets, f)
+}
+
+/* The layout for f: nil (the format's default) unless layout flags were given. */
+func options(f convert.Format) *convert.Options {
+	if len(layout) == 0 {
+		return nil
+	}
+	o := convert.DefaultOptions(f)
+	for _, fl := range layout {
+		switch fl.Name {
+		case "pad":
+			o.Pad = *pad
+		case "sep":
+			o.Separator = *sep
+		case "nosep":
+			o.NoSeparator = *noSep
+		case "upper":
+			o.Upper = *upper
+		case "prefix":
+			o.Prefix = *prefix
+		case "group":
+			o.GroupBits = *group
+		case "compress":
+			o.Compress = *compress
+		case "embed4":
+			o.EmbedIPv4 = *embed4
 		}
+	}
+	return o
+}
 
-		b, err := convert.ConvertIP(line, convertTo)
+/* Parses v from -from's format (or as an IP or CIDR block), then converts it to each target. */
+func convertOne(v string, parseFrom convert.Format, targets []convert.Format) record {
+	r := record{Input: v}
+	var ip net.IP
+	var err error
+	if slaacPrefix != nil {
+		var mac net.HardwareAddr
+		if mac, err = net.ParseMAC(v); err == nil {
+			ip, err = convert.SLAAC(slaacPrefix, mac)
+		}
+	} else if *from != "" {
+		ip, err = convert.ParseIP(v, parseFrom)
+	} else if strings.ContainsRune(v, '/') {
+		ip, _, err = net.ParseCIDR(v)
+	} else if ip = net.ParseIP(v); ip == nil {
+		err = fmt.Errorf("couldn't parse ip [%s]", v)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	/* Keep "10.0.0.1" apart from "::ffff:10.0.0.1" for -embedded. */
+	if p4 := ip.To4(); p4 != nil && !strings.ContainsRune(v, ':') {
+		ip = p4
+	}
+	r.Ip = ip.String()
+	r.Family = "ipv6"
+	if ip.To4() != nil {
+		r.Family = "ipv4"
+	}
+	if *classify {
+		for _, c := range convert.Classify(ip) {
+			r.Class = append(r.Class, class{c.Prefix.String(), c.Kind, c.Name, c.Rfc, c.Global})
+		}
+		r.Scope = convert.MulticastScope(ip)
+	}
+	if *embedded {
+		if e := convert.ExtractIPv4(ip, nat64Prefixes...); e != nil {
+			r.Embed = &embed{Kind: e.Kind, Ipv4: e.Ipv4.String(), Port: e.Port, Flags: e.Flags}
+			if e.Prefix != nil {
+				r.Embed.Prefix = e.Prefix.String()
+			}
+			if e.Server != nil {
+				r.Embed.Server = e.Server.String()
+			}
+		}
+	}
+	/* Most addresses have no MAC in them; that's no reason to fail the rest. */
+	if *toMac && convert.IsEUI64(ip) {
+		mac, _ := convert.MACFromIP(ip)
+		r.Mac = mac.String()
+	}
+	r.Formats = make(map[string]string, len(targets))
+	for _, f := range targets {
+		out, err := convert.ConvertIPOptions(r.Ip, f, options(f))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-		} else {
-			fmt.Println(b)
+			r.Error = err.Error()
+			return r
 		}
+		r.Formats[f.String()] = out
+	}
+	return r
+}
+
+/* Writes each input's result as plain text, JSON lines, CSV or TSV. */
+type writer struct {
+	format  string
+	targets []convert.Format
+	csv     *csv.Writer
+	json    *json.Encoder
+}
+
+func newWriter(format string, targets []convert.Format) *writer {
+	w := &writer{format: format, targets: targets}
+	switch format {
+	case "json":
+		w.json = json.NewEncoder(os.Stdout)
+	case "csv", "tsv":
+		w.csv = csv.NewWriter(os.Stdout)
+		if format == "tsv" {
+			w.csv.Comma = '\t'
+		}
+		header := []string{"line", "input", "family", "ip"}
+		for _, f := range targets {
+			header = append(header, f.String())
+		}
+		if *classify {
+			header = append(header, "kind", "registry", "prefix", "rfc", "scope")
+		}
+		if *embedded {
+			header = append(header, "embedded", "ipv4", "teredo_server", "teredo_port")
+		}
+		if *toMac {
+			header = append(header, "mac")
+		}
+		w.csv.Write(append(header, "error"))
+	}
+	return w
+}
+
+/*
+ * Converts and writes one input, numbered n and described by where in error
+ * messages. Returns false if it failed; with -strict, exits instead.
+ */
+func (w *writer) write(where string, n int, v string, parseFrom convert.Format, targets []convert.Format) bool {
+	if *scan {
+		return w.scanText(where, v, parseFrom, targets)
+	}
+	if *subnet {
+		s, err := convert.CalcSubnet(v)
+		if err != nil {
+			return w.fail(where, err.Error())
+		}
+		fmt.Println(s.String() + "\n")
+		return true
+	}
 
+	r := convertOne(v, parseFrom, targets)
+	r.Line = n
+	switch w.format {
+	case "json":
+		if err := w.json.Encode(r); err != nil {
+			log.Fatal(err)
+		}
+	case "csv", "tsv":
+		row := []string{fmt.Sprint(r.Line), r.Input, r.Family, r.Ip}
+		for _, f := range targets {
+			row = append(row, r.Formats[f.String()])
+		}
+		if *classify {
+			var c class
+			if len(r.Class) > 0 {
+				c = r.Class[0]
+			}
+			row = append(row, c.Kind, c.Name, c.Prefix, c.Rfc, r.Scope)
+		}
+		if *embedded {
+			var e embed
+			if r.Embed != nil {
+				e = *r.Embed
+			}
+			port := ""
+			if e.Kind == "teredo" {
+				port = fmt.Sprint(e.Port)
+			}
+			row = append(row, e.Kind, e.Ipv4, e.Server, port)
+		}
+		if *toMac {
+			row = append(row, r.Mac)
+		}
+		w.csv.Write(append(row, r.Error))
+	default:
+		if r.Error != "" {
+			break
+		} else if len(targets) == 0 && !*classify && !*embedded && !*toMac {
+			fmt.Println(r.Ip)
+		}
+		if r.Mac != "" {
+			fmt.Println(r.Mac)
+		} else if *toMac {
+			fmt.Println(r.Input + ": no EUI-64 mac")
+		}
+		for _, f := range targets {
+			if out, ok := r.Formats[f.String()]; ok {
+				fmt.Println(out)
+			}
+		}
+		if *classify {
+			fmt.Println(classLine(r))
+		}
+		if *embedded {
+			fmt.Println(embedLine(r))
+		}
+	}
+	if r.Error != "" {
+		return w.fail(where, r.Error)
+	}
+	return true
+}
+
+/* Writes text with every address in it converted (or annotated) for -scan. */
+func (w *writer) scanText(where, text string, parseFrom convert.Format, targets []convert.Format) bool {
+	var errs []string
+	out := convert.ReplaceAll(text, func(m convert.Match) string {
+		r := convertOne(m.Text, parseFrom, targets)
+		if r.Error != "" {
+			errs = append(errs, r.Error)
+			return m.Text
+		}
+		if *annotate {
+			if notes := annotations(r, targets); len(notes) > 0 {
+				return m.Text + " [" + strings.Join(notes, ", ") + "]"
+			}
+			return m.Text
+		}
+		conv := r.Ip
+		if len(targets) > 0 {
+			conv = r.Formats[targets[0].String()]
+		}
+		/* A CIDR block keeps its length. */
+		if m.Net != nil {
+			conv += m.Text[strings.IndexByte(m.Text, '/'):]
+		}
+		return conv
+	})
+	fmt.Println(out)
+	if len(errs) > 0 {
+		return w.fail(where, strings.Join(errs, "; "))
+	}
+	return true
+}
+
+/* What -annotate adds after an address: each conversion, then -classify, -embedded and -mac. */
+func annotations(r record, targets []convert.Format) []string {
+	var notes []string
+	for _, f := range targets {
+		notes = append(notes, r.Formats[f.String()])
+	}
+	if len(r.Class) > 0 {
+		note := r.Class[0].Kind
+		if r.Scope != "" {
+			note += " " + r.Scope
+		}
+		notes = append(notes, note)
+	}
+	if r.Embed != nil {
+		notes = append(notes, r.Embed.Kind+" "+r.Embed.Ipv4)
+	}
+	if r.Mac != "" {
+		notes = append(notes, "mac "+r.Mac)
+	}
+	return notes
+}
+
+/* Reports a failed input on STDERR (as well as in its record, for -format). */
+func (w *writer) fail(where, msg string) bool {
+	fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", where, msg)
+	if *strict {
+		w.flush()
+		os.Exit(1)
+	}
+	return false
+}
+
+func (w *writer) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+/* Formats a record's classification as "ip: kind (prefix name, rfc)". */
+func classLine(r record) string {
+	if len(r.Class) == 0 {
+		return r.Ip + ": unclassified"
+	}
+	c := r.Class[0]
+	line := fmt.Sprintf("%s: %s (%s %s, %s)", r.Ip, c.Kind, c.Prefix, c.Name, c.Rfc)
+	if r.Scope != "" {
+		line += " scope " + r.Scope
+	}
+	return line
+}
+
+/*
+ * Formats a record's embedded IPv4 as "input: kind ipv4", plus the Teredo
+ * details. The input is shown as given, since its form is what's decoded.
+ */
+func embedLine(r record) string {
+	e := r.Embed
+	if e == nil {
+		return r.Input + ": no embedded ipv4"
+	}
+	line := fmt.Sprintf("%s: %s %s", r.Input, e.Kind, e.Ipv4)
+	if e.Kind == "teredo" {
+		line += fmt.Sprintf(" port %d server %s", e.Port, e.Server)
+	} else if e.Prefix != "" {
+		line += " prefix " + e.Prefix
 	}
+	return line
 }
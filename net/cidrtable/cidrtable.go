@@ -1,7 +1,14 @@
 package cidrtable
 
 import (
+	"bytes"
+	"fmt"
 	"net"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/yargevad/net/ip/arith"
 )
 
 /*
@@ -9,38 +16,279 @@ import (
  */
 
 type IpRange struct {
-	start net.IP    /* Beginning of the IP Range. */
-	end   net.IP    /* End of the IP Range. */
-	cidr  net.IPNet /* The IP Range in CIDR notation (<ip>/32, etc). */
+	start net.IP      /* Beginning of the IP Range. */
+	end   net.IP      /* End of the IP Range. */
+	cidr  net.IPNet   /* The IP Range in CIDR notation (<ip>/32, etc), if it is a single block. */
+	value interface{} /* Caller data attached to every IP in the Range, nil if none. */
 }
 
 type IpRangeNode struct {
 	data     IpRange      /* IP and CIDR info. */
 	prevNode *IpRangeNode /* Previous node. */
 	nextNode *IpRangeNode /* Next node. */
+	left     *IpRangeNode /* Tree child with lower IPs. */
+	right    *IpRangeNode /* Tree child with higher IPs. */
+	prio     uint32       /* Random tree priority, parents are higher than their children. */
 }
 
-/* Key is a stringified IP w/o mask. */
+/*
+ * Key is a stringified IP w/o mask.
+ *
+ * Deprecated: CidrTable finds ranges through its tree now and no longer
+ * keeps these; the type stays so existing code still compiles.
+ */
 type IpTable map[string]IpRangeNode
 
 type CidrTable struct {
-	list    *IpRangeNode /* Beginning of the list of IP Ranges, sorted ascending by IP. */
-	prevIps IpTable      /* List of IPs just before each Range, with a ref to the range. */
-	nextIps IpTable      /* List of IPs just after each Range, with a ref to the range. */
+	list    *IpRangeNode   /* Beginning of the list of IP Ranges, sorted ascending by IP. */
+	last    *IpRangeNode   /* End of the list. */
+	root    *IpRangeNode   /* The same nodes as a treap keyed by start, to find where a range goes. */
+	seed    uint32         /* State for the treap priorities. */
+	index   []*IpRangeNode /* The list as a slice for lookups, nil when stale. */
+	indexMu sync.Mutex     /* Guards building the index from concurrent lookups. */
 }
 
 func InitCidr() (*CidrTable, error) {
 	var c CidrTable
-	c.prevIps = make(IpTable)
-	c.nextIps = make(IpTable)
 	return &c, nil
 }
 
+func newIpRange(start, end net.IP, value interface{}) IpRange {
+	r := IpRange{start: start, end: end, value: value}
+	if cidrs := rangeToCidrs(start, end); len(cidrs) == 1 {
+		r.cidr = cidrs[0]
+	}
+	return r
+}
+
+func (r IpRange) Start() net.IP      { return r.start }
+func (r IpRange) End() net.IP        { return r.end }
+func (r IpRange) Value() interface{} { return r.value }
+
+/* Returns the smallest list of CIDR blocks that exactly covers the range. */
+func (r IpRange) Cidrs() []net.IPNet {
+	if r.cidr.IP != nil {
+		return []net.IPNet{r.cidr}
+	}
+	return rangeToCidrs(r.start, r.end)
+}
+
+func (r IpRange) String() string {
+	if r.cidr.IP != nil {
+		return r.cidr.String()
+	}
+	return fmt.Sprintf("%s-%s", r.start, r.end)
+}
+
+/* Adds a CIDR block (or a bare IP, as a single-address block) to the table. */
 func (c *CidrTable) AddCidr(cstr string) error {
-	//ip, ipnet, err := net.ParseCIDR(cstr)
-	_, _, err := net.ParseCIDR(cstr)
+	return c.AddCidrValue(cstr, nil)
+}
+
+/*
+ * Adds a CIDR block carrying value, which replaces the value of any addresses
+ * already in the table. Values must be comparable; ranges only merge when equal.
+ */
+func (c *CidrTable) AddCidrValue(cstr string, value interface{}) error {
+	var start net.IP
+	var ones int
+	if ip := net.ParseIP(cstr); ip != nil {
+		start = arith.Normalize(ip)
+		ones = 8 * len(start)
+	} else {
+		_, ipnet, err := net.ParseCIDR(cstr)
+		if err != nil {
+			return err
+		}
+		start, ones, _ = normalizeNet(*ipnet)
+	}
+	c.insert(start, arith.Last(start, ones), value)
+	return nil
+}
+
+/* Adds every address from start through end, inclusive, to the table. */
+func (c *CidrTable) AddRange(start, end net.IP) error {
+	return c.AddRangeValue(start, end, nil)
+}
+
+func (c *CidrTable) AddRangeValue(start, end net.IP, value interface{}) error {
+	s, e, err := checkRange(start, end)
 	if err != nil {
 		return err
 	}
+	c.insert(s, e, value)
 	return nil
 }
+
+/*
+ * Merges [start, end] into the list, joining it with every range it overlaps
+ * or touches that has the same value. Ranges with other values are trimmed
+ * (or split) around it. The walk starts at the last range beginning no later
+ * than just past end, found in the tree, and only visits ranges it changes.
+ */
+func (c *CidrTable) insert(start, end net.IP, value interface{}) {
+	var right, remnant *IpRangeNode
+	after, ok := arith.Next(end)
+	if !ok {
+		after = end
+	}
+	n := c.floor(after)
+	if n == nil {
+		right = c.list
+	} else {
+		right = n.nextNode
+	}
+	for n != nil && reaches(n, start) {
+		d := n.data
+		left := n.prevNode
+		if !sameValue(d.value, value) {
+			if arith.Compare(d.start, end) > 0 {
+				/* Only touches on the right, leave it be. */
+				right, n = n, left
+				continue
+			} else if arith.Compare(d.end, start) < 0 {
+				/* Only touches on the left, and so does everything before it. */
+				break
+			}
+		}
+		c.unlink(n)
+		n = left
+		if sameValue(d.value, value) {
+			if arith.Compare(d.start, start) < 0 {
+				start = d.start
+			}
+			if arith.Compare(d.end, end) > 0 {
+				end = d.end
+			}
+			continue
+		}
+		if arith.Compare(d.end, end) > 0 {
+			after, _ := arith.Next(end)
+			r := &IpRangeNode{data: newIpRange(after, d.end, d.value)}
+			c.link(r, n, right)
+			right = r
+		}
+		if arith.Compare(d.start, start) < 0 {
+			before, _ := arith.Prev(start)
+			remnant = &IpRangeNode{data: newIpRange(d.start, before, d.value)}
+			break
+		}
+	}
+	if remnant != nil {
+		c.link(remnant, n, right)
+		n = remnant
+	}
+	c.link(&IpRangeNode{data: newIpRange(start, end, value)}, n, right)
+}
+
+/* Compares two range values, treating incomparable ones as different. */
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+/* True if node n ends at or past ip, or just before it. */
+func reaches(n *IpRangeNode, ip net.IP) bool {
+	if arith.Compare(n.data.end, ip) >= 0 {
+		return true
+	}
+	next, ok := arith.Next(n.data.end)
+	return ok && bytes.Equal(next, ip)
+}
+
+/* Inserts n between left and right, either of which may be nil. */
+func (c *CidrTable) link(n, left, right *IpRangeNode) {
+	n.prevNode, n.nextNode = left, right
+	if left == nil {
+		c.list = n
+	} else {
+		left.nextNode = n
+	}
+	if right == nil {
+		c.last = n
+	} else {
+		right.prevNode = n
+	}
+	c.treeAdd(n)
+	c.index = nil
+}
+
+func (c *CidrTable) unlink(n *IpRangeNode) {
+	if n.prevNode == nil {
+		c.list = n.nextNode
+	} else {
+		n.prevNode.nextNode = n.nextNode
+	}
+	if n.nextNode == nil {
+		c.last = n.prevNode
+	} else {
+		n.nextNode.prevNode = n.prevNode
+	}
+	c.root = treeRemove(c.root, n)
+	n.prevNode, n.nextNode = nil, nil
+	c.index = nil
+}
+
+/*
+ * Returns the range containing ip, if any. Lookups are safe for concurrent
+ * use, as long as nothing is being added to the table at the same time.
+ */
+func (c *CidrTable) Lookup(ip net.IP) (IpRange, bool) {
+	ip = arith.Normalize(ip)
+	if ip == nil {
+		return IpRange{}, false
+	}
+	c.indexMu.Lock()
+	if c.index == nil {
+		for n := c.list; n != nil; n = n.nextNode {
+			c.index = append(c.index, n)
+		}
+	}
+	index := c.index
+	c.indexMu.Unlock()
+
+	i := sort.Search(len(index), func(i int) bool {
+		return arith.Compare(index[i].data.end, ip) >= 0
+	})
+	if i < len(index) && arith.Compare(index[i].data.start, ip) <= 0 {
+		return index[i].data, true
+	}
+	return IpRange{}, false
+}
+
+func (c *CidrTable) Contains(ip net.IP) bool {
+	_, ok := c.Lookup(ip)
+	return ok
+}
+
+/* Number of (merged) ranges in the table. */
+func (c *CidrTable) Len() int {
+	n := 0
+	for r := c.list; r != nil; r = r.nextNode {
+		n++
+	}
+	return n
+}
+
+/* Returns the ranges in the table, sorted ascending by IP. */
+func (c *CidrTable) Ranges() []IpRange {
+	var ranges []IpRange
+	for n := c.list; n != nil; n = n.nextNode {
+		ranges = append(ranges, n.data)
+	}
+	return ranges
+}
+
+/* Returns the table as the smallest sorted list of CIDR blocks covering it. */
+func (c *CidrTable) Cidrs() []net.IPNet {
+	var cidrs []net.IPNet
+	for n := c.list; n != nil; n = n.nextNode {
+		cidrs = append(cidrs, n.data.Cidrs()...)
+	}
+	return cidrs
+}
@@ -0,0 +1,819 @@
+package cidrtable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+func loadTable(t *testing.T, cidrs ...string) *CidrTable {
+	c, _ := InitCidr()
+	for _, cidr := range cidrs {
+		if err := c.AddCidr(cidr); err != nil {
+			t.Fatalf("AddCidr(%s): %s\n", cidr, err)
+		}
+	}
+	return c
+}
+
+func TestAddCidrMerge(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"10.0.0.0/24"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.128/25", "10.0.0.0/25"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.0/24", "10.0.0.5"}, "10.0.0.0/24"},
+		{[]string{"10.0.1.0/24", "10.0.0.0/24"}, "10.0.0.0/23"},
+		{[]string{"10.0.0.0/24", "10.0.2.0/24"}, "10.0.0.0/24 10.0.2.0/24"},
+		{[]string{"10.0.0.0/24", "10.0.2.0/24", "10.0.1.0/24"}, "10.0.0.0/23 10.0.2.0/24"},
+		{[]string{"10.0.1.0/24", "10.0.2.0/24"}, "10.0.1.0/24 10.0.2.0/24"},
+		{[]string{"2001:db8::/33", "10.0.0.0/8", "2001:db8:8000::/33"}, "10.0.0.0/8 2001:db8::/32"},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1", "::/0"}, "0.0.0.0/0 ::/0"},
+		{[]string{"255.255.255.255", "::"}, "255.255.255.255/32 ::/128"},
+		{[]string{"::ffff:10.0.0.0/120", "10.0.1.0/24"}, "10.0.0.0/23"},
+		{[]string{"::ffff:0:0/96", "::/127"}, "0.0.0.0/0 ::/127"},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, cidr := range loadTable(t, c.in...).Cidrs() {
+			got = append(got, cidr.String())
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%v => [%s] (%s)\n", c.in, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	c := loadTable(t, "10.0.0.0/24", "::ffff:10.0.2.0/120", "2001:db8::/32")
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.0", true},
+		{"10.0.0.255", true},
+		{"10.0.1.0", false},
+		{"10.0.2.17", true},
+		{"9.255.255.255", false},
+		{"2001:db8:ffff::1", true},
+		{"2001:db9::", false},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:10.0.2.1", true},
+		{"::a00:201", false},
+	}
+	for _, tc := range cases {
+		if got := c.Contains(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("Contains(%s) = %t (%t)\n", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := loadTable(t, "10.0.0.0/24", "10.0.1.0/24", "192.168.0.0/16", "2001:db8::/32")
+	o := &ExportOptions{Name: "allow", ChunkSize: 1}
+	cases := []struct {
+		name  string
+		write func(*bytes.Buffer) error
+		want  []string
+	}{
+		{"iptables", func(b *bytes.Buffer) error { return c.WriteIptables(b, o) },
+			[]string{"-A allow -s 10.0.0.0/23 -j ACCEPT\n", "-A allow -s 192.168.0.0/16 -j ACCEPT\n"}},
+		{"ip6tables", func(b *bytes.Buffer) error { return c.WriteIp6tables(b, o) },
+			[]string{"-A allow -s 2001:db8::/32 -j ACCEPT\n"}},
+		{"nftables", func(b *bytes.Buffer) error { return c.WriteNftables(b, o) },
+			[]string{"add element inet allow allow4 { 10.0.0.0/23 }\n", "add element inet allow allow4 { 192.168.0.0/16 }\n"}},
+		{"ipset", func(b *bytes.Buffer) error { return c.WriteIpset(b, o) },
+			[]string{"add allow4-1 192.168.0.0/16\n", "create allow6 hash:net family inet6"}},
+		{"pf", func(b *bytes.Buffer) error { return c.WritePf(b, o) },
+			[]string{"table <allow> persist {", "\t2001:db8::/32 }\n"}},
+		{"aws", func(b *bytes.Buffer) error { return c.WriteAwsSecurityGroups(b, o) },
+			[]string{`"GroupName": "allow-2"`, `"CidrIpv6": "2001:db8::/32"`}},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		if err := tc.write(&buf); err != nil {
+			t.Errorf("%s: %s\n", tc.name, err)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: missing [%s] in:\n%s", tc.name, want, buf.String())
+			}
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	cases := []struct {
+		name string
+		read func(*CidrTable, io.Reader, *ImportFilter) error
+		in   string
+		f    *ImportFilter
+		want string
+	}{
+		{"aws", (*CidrTable).ReadAwsIpRanges, `{"prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+			{"ip_prefix": "52.94.76.0/22", "region": "us-west-2", "service": "AMAZON"},
+			{"ip_prefix": "52.94.76.0/23", "region": "us-west-2", "service": "EC2"}],
+			"ipv6_prefixes": [{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "EC2"}]}`,
+			&ImportFilter{Region: "us-west-2"}, "52.94.76.0/22 2600:1f14::/35"},
+		{"gcp", (*CidrTable).ReadGcpCloud, `{"prefixes": [
+			{"ipv4Prefix": "34.80.0.0/15", "service": "Google Cloud", "scope": "asia-east1"},
+			{"ipv6Prefix": "2600:1900:4030::/44", "service": "Google Cloud", "scope": "asia-east1"},
+			{"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud", "scope": "africa-south1"}]}`,
+			&ImportFilter{Region: "asia-east1"}, "34.80.0.0/15 2600:1900:4030::/44"},
+		{"azure", (*CidrTable).ReadAzureServiceTags, `{"values": [
+			{"name": "AzureCloud.eastus", "properties": {"region": "eastus", "addressPrefixes": ["13.68.128.0/17", "2603:1030:20e::/47"]}},
+			{"name": "Storage", "properties": {"systemService": "AzureStorage", "addressPrefixes": ["13.65.24.0/21"]}}]}`,
+			&ImportFilter{Name: "azurecloud.eastus"}, "13.68.128.0/17 2603:1030:20e::/47"},
+		{"rir", (*CidrTable).ReadRirDelegated, `2|arin|1700000000|3|19830101|20231101|-0500
+arin|*|ipv4|*|2|summary
+arin|US|asn|1|1|20000101|assigned
+arin|US|ipv4|3.0.0.0|16777216|19880223|allocated|e5e3b9c13678dfc483fb1f819d70883c
+arin|CA|ipv4|24.36.0.0|768|20000101|assigned|x
+arin|US|ipv4|23.0.0.0|768|20100101|assigned|x
+arin|US|ipv6|2600::|29|20100101|allocated|x`,
+			&ImportFilter{Country: "US"}, "3.0.0.0/8 23.0.0.0/23 23.0.2.0/24 2600::/29"},
+		{"hosts", (*CidrTable).ReadHostList, "10.0.0.0/30 lan\n10.0.0.4/30 lan\n10.0.0.8/31 lan\n10.0.1.1\n10.0.2.0/30 wan\n2001:db8::/127 lan\n",
+			&ImportFilter{Name: "lan"}, "10.0.0.1/32 10.0.0.2/32 10.0.0.5/32 10.0.0.6/32 10.0.0.8/31 2001:db8::/127"},
+		{"rir overflow", (*CidrTable).ReadRirDelegated, `apnic|JP|ipv4|255.255.255.0|1024|20100101|allocated
+apnic|JP|ipv4|1.0.0.0|256|20100101|assigned`,
+			&ImportFilter{Status: "allocated"}, "255.255.255.0/24"},
+		{"cisco", (*CidrTable).ReadPrefixList, `!
+ip prefix-list ALLOW seq 5 permit 10.0.0.0/8 le 24
+ip prefix-list ALLOW seq 10 deny 10.1.0.0/16
+ip prefix-list OTHER permit 172.16.0.0/12
+ipv6 prefix-list ALLOW seq 5 permit 2001:db8::/32 ge 48`,
+			&ImportFilter{Name: "ALLOW"}, "10.0.0.0/8 2001:db8::/32"},
+		{"juniper", (*CidrTable).ReadPrefixList, `policy-options {
+    prefix-list ALLOW {
+        192.0.2.0/24;
+        2001:db8::/32;
+        apply-path "interfaces <*> unit <*> family inet address <*>";
+    }
+    prefix-list OTHER {
+        198.51.100.0/24;
+    }
+}
+set policy-options prefix-list ALLOW 203.0.113.0/24`,
+			&ImportFilter{Name: "ALLOW"}, "192.0.2.0/24 203.0.113.0/24 2001:db8::/32"},
+	}
+	for _, tc := range cases {
+		c, _ := InitCidr()
+		if err := tc.read(c, strings.NewReader(tc.in), tc.f); err != nil {
+			t.Errorf("%s: %s\n", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, cidr := range c.Cidrs() {
+			got = append(got, cidr.String())
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s => [%s] (%s)\n", tc.name, strings.Join(got, " "), tc.want)
+		}
+	}
+}
+
+func TestAddCidrValue(t *testing.T) {
+	cases := []struct {
+		in   []string /* cidr=value pairs */
+		want string
+	}{
+		{[]string{"10.0.0.0/24=a", "10.0.1.0/24=a"}, "10.0.0.0/23=a"},
+		{[]string{"10.0.0.0/24=a", "10.0.1.0/24=b"}, "10.0.0.0/24=a 10.0.1.0/24=b"},
+		{[]string{"10.0.0.0/8=a", "10.1.0.0/16=b"}, "10.0.0.0/16=a 10.1.0.0/16=b 10.2.0.0-10.255.255.255=a"},
+		{[]string{"10.0.0.0/8=a", "10.0.0.0/16=b"}, "10.0.0.0/16=b 10.1.0.0-10.255.255.255=a"},
+		{[]string{"10.0.0.0/8=a", "10.255.0.0/16=b"}, "10.0.0.0-10.254.255.255=a 10.255.0.0/16=b"},
+		{[]string{"10.0.0.0/8=a", "10.1.0.0/16=b", "10.1.0.0/16=a"}, "10.0.0.0/8=a"},
+		{[]string{"10.0.0.0/24=a", "10.0.2.0/24=c", "10.0.1.0/24=b"}, "10.0.0.0/24=a 10.0.1.0/24=b 10.0.2.0/24=c"},
+		{[]string{"10.0.0.0/25=a", "10.0.0.128/25=b", "10.0.0.0/24=c"}, "10.0.0.0/24=c"},
+		{[]string{"10.0.0.0/27=a", "10.0.0.32/27=b", "10.0.0.64/26=c", "10.0.0.0/26=d"}, "10.0.0.0/26=d 10.0.0.64/26=c"},
+		{[]string{"10.0.0.0/27=a", "10.0.0.64/27=b", "10.0.0.32/27=b"}, "10.0.0.0/27=a 10.0.0.32-10.0.0.95=b"},
+	}
+	for _, tc := range cases {
+		c, _ := InitCidr()
+		for _, kv := range tc.in {
+			parts := strings.Split(kv, "=")
+			if err := c.AddCidrValue(parts[0], parts[1]); err != nil {
+				t.Fatalf("AddCidrValue(%s): %s\n", kv, err)
+			}
+		}
+		var got []string
+		for _, r := range c.Ranges() {
+			got = append(got, fmt.Sprintf("%s=%v", r.String(), r.Value()))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%v => [%s] (%s)\n", tc.in, strings.Join(got, " "), tc.want)
+		}
+	}
+}
+
+/* Random overlapping inserts, checked address by address against a plain array. */
+func TestAddCidrValueRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c, _ := InitCidr()
+	var model [1024]string
+	for i := 0; i < 3000; i++ {
+		ones := 22 + rng.Intn(11)
+		first := rng.Intn(1024) &^ (1<<uint(32-ones) - 1)
+		value := string(rune('a' + rng.Intn(3)))
+		cidr := fmt.Sprintf("10.0.%d.%d/%d", first>>8, first&255, ones)
+		if err := c.AddCidrValue(cidr, value); err != nil {
+			t.Fatalf("AddCidrValue(%s): %s\n", cidr, err)
+		}
+		for a := first; a < first+1<<uint(32-ones); a++ {
+			model[a] = value
+		}
+	}
+	for a, want := range model {
+		ip := net.IPv4(10, 0, byte(a>>8), byte(a))
+		r, ok := c.Lookup(ip)
+		if got, _ := r.Value().(string); got != want {
+			t.Fatalf("Lookup(%s) = %v %t (%s)\n", ip, r, ok, want)
+		}
+	}
+	ranges := c.Ranges()
+	for i := 1; i < len(ranges); i++ {
+		if next, _ := arith.Next(ranges[i-1].End()); next.Equal(ranges[i].Start()) && ranges[i-1].Value() == ranges[i].Value() {
+			t.Errorf("unmerged ranges %s and %s (%v)\n", ranges[i-1], ranges[i], ranges[i].Value())
+		}
+	}
+}
+
+/* Builds a TABLE_DUMP_V2 RIB record with one entry per AS path. */
+func mrtRecord(subtype uint16, prefix string, paths ...[]uint32) []byte {
+	_, ipnet, _ := net.ParseCIDR(prefix)
+	ones, _ := ipnet.Mask.Size()
+	body := []byte{0, 0, 0, 0, byte(ones)}
+	body = append(body, ipnet.IP[:(ones+7)/8]...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(paths)))
+	for _, path := range paths {
+		attr := []byte{0x40, 1, 1, 0} /* ORIGIN IGP */
+		seg := []byte{2, byte(len(path))}
+		for _, asn := range path {
+			seg = binary.BigEndian.AppendUint32(seg, asn)
+		}
+		attr = append(attr, 0x50, bgpAttrAsPath)
+		attr = binary.BigEndian.AppendUint16(attr, uint16(len(seg)))
+		attr = append(attr, seg...)
+		body = append(body, 0, 0, 0, 0, 0, 0)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(attr)))
+		body = append(body, attr...)
+	}
+	rec := []byte{0, 0, 0, 0}
+	rec = binary.BigEndian.AppendUint16(rec, mrtTableDumpV2)
+	rec = binary.BigEndian.AppendUint16(rec, subtype)
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(body)))
+	return append(rec, body...)
+}
+
+func TestReadMrt(t *testing.T) {
+	var dump []byte
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.0.0/24", []uint32{3356, 13335}, []uint32{174, 2914, 13335})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.0.128/25", []uint32{6939, 64512})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.0.0/16", []uint32{6939, 4608})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.4.0/22", []uint32{6939, 64500})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.5.0/24", []uint32{6939, 64501})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "1.0.255.0/24", []uint32{6939, 64502})...)
+	dump = append(dump, mrtRecord(mrtRibIpv4Unicast, "255.255.255.0/24", []uint32{6939, 64503})...)
+	dump = append(dump, mrtRecord(mrtRibIpv6Unicast, "2001:db8::/32", []uint32{6939, 64496})...)
+	dump = append(dump, mrtRecord(mrtRibIpv6Unicast, "::/0", []uint32{6939})...)
+
+	c, _ := InitCidr()
+	if err := c.ReadMrt(bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip   string
+		want string
+	}{
+		{"1.0.0.1", "AS13335 [3356 13335]"},
+		{"1.0.0.200", "AS64512 [6939 64512]"},
+		{"1.0.1.1", "AS4608 [6939 4608]"},
+		{"1.0.4.1", "AS64500 [6939 64500]"},
+		{"1.0.5.1", "AS64501 [6939 64501]"},
+		{"1.0.6.1", "AS64500 [6939 64500]"},
+		{"1.0.8.1", "AS4608 [6939 4608]"},
+		{"1.0.255.255", "AS64502 [6939 64502]"},
+		{"255.255.255.255", "AS64503 [6939 64503]"},
+		{"2001:db8::1", "AS64496 [6939 64496]"},
+		{"2001:db9::1", "AS6939 [6939]"},
+		{"::1", "AS6939 [6939]"},
+	}
+	if got := c.Len(); got != 12 {
+		t.Errorf("%d ranges (12)\n", got)
+	}
+	for _, tc := range cases {
+		r, ok := c.Lookup(net.ParseIP(tc.ip))
+		if !ok {
+			t.Errorf("%s not found\n", tc.ip)
+		} else if got := fmt.Sprint(r.Value()); got != tc.want {
+			t.Errorf("%s => [%s] (%s)\n", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestMmdbRoundTrip(t *testing.T) {
+	city := map[string]interface{}{
+		"city":     "Springfield",
+		"location": map[string]interface{}{"latitude": 39.78, "longitude": -89.65},
+		"tags":     []interface{}{"a", uint32(70000)},
+		"internal": true,
+		"asn":      uint64(1 << 40),
+		"offset":   int32(-5),
+	}
+	for _, ipVersion := range []int{0, 6} {
+		c, _ := InitCidr()
+		c.AddCidrValue("0.0.0.0/0", "default")
+		c.AddCidrValue("10.0.0.0/8", "ten")
+		c.AddCidrValue("10.1.0.0/16", city)
+		if ipVersion == 6 {
+			c.AddCidrValue("2001:db8::/32", map[string]string{"owner": "docs"})
+		}
+
+		var buf bytes.Buffer
+		if err := c.WriteMmdb(&buf, &MmdbOptions{IpVersion: ipVersion, DatabaseType: "Test"}); err != nil {
+			t.Fatalf("ip version %d: %s\n", ipVersion, err)
+		}
+		read, _ := InitCidr()
+		if err := read.ReadMmdb(&buf); err != nil {
+			t.Fatalf("ip version %d: %s\n", ipVersion, err)
+		}
+
+		cases := []struct {
+			ip   string
+			want interface{}
+		}{
+			{"1.2.3.4", "default"},
+			{"10.2.3.4", "ten"},
+			{"10.1.2.3", city},
+			{"255.255.255.255", "default"},
+		}
+		if ipVersion == 6 {
+			cases = append(cases, struct {
+				ip   string
+				want interface{}
+			}{"2001:db8::1", map[string]interface{}{"owner": "docs"}})
+		}
+		for _, tc := range cases {
+			r, ok := read.Lookup(net.ParseIP(tc.ip))
+			if !ok {
+				t.Errorf("ip version %d: %s not found\n", ipVersion, tc.ip)
+			} else if !reflect.DeepEqual(r.Value(), tc.want) {
+				t.Errorf("ip version %d: %s => %#v (%#v)\n", ipVersion, tc.ip, r.Value(), tc.want)
+			}
+		}
+		if read.Contains(net.ParseIP("2001:db9::1")) {
+			t.Errorf("ip version %d: 2001:db9::1 found\n", ipVersion)
+		}
+	}
+}
+
+func TestMmdbIpv4InIpv6(t *testing.T) {
+	cases := []struct {
+		in   []string /* cidr=value pairs */
+		want []string /* ip=value pairs, value empty if not found */
+		err  bool
+	}{
+		{[]string{"10.0.0.0/8=ten", "::/1=low"}, []string{"10.1.2.3=ten", "2001:db8::1=low", "10.0.0.0=ten", "11.0.0.0=low", "8000::="}, false},
+		{[]string{"10.0.0.0/8=ten", "::/0=all"}, []string{"10.1.2.3=ten", "2001:db8::1=all", "8000::=all", "11.0.0.0=all"}, false},
+		{[]string{"10.0.0.0/8=ten", "::/8=v6wide"}, []string{"::1=v6wide", "::a01:203=ten", "0.0.0.1=v6wide", "100::="}, false},
+		{[]string{"10.0.0.0/8=ten", "2001:db8::/32=doc"}, []string{"10.1.2.3=ten", "::1=", "11.0.0.0=", "2001:db8::1=doc"}, false},
+		{[]string{"10.0.0.0/8=ten", "::a00:0/120=v6"}, nil, true},
+		{[]string{"10.0.0.0/8=ten", "::a00:0/96=v6"}, nil, true},
+	}
+	for _, tc := range cases {
+		c, _ := InitCidr()
+		for _, kv := range tc.in {
+			parts := strings.Split(kv, "=")
+			c.AddCidrValue(parts[0], parts[1])
+		}
+		var buf bytes.Buffer
+		err := c.WriteMmdb(&buf, &MmdbOptions{IpVersion: 6})
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: no error\n", tc.in)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%v: %s\n", tc.in, err)
+		}
+		db := buf.Bytes()
+		read, _ := InitCidr()
+		if err := read.ReadMmdb(bytes.NewReader(db)); err != nil {
+			t.Fatalf("%v: %s\n", tc.in, err)
+		}
+		for _, kv := range tc.want {
+			parts := strings.Split(kv, "=")
+			ip := net.ParseIP(parts[0])
+			/* The reader hands back ::/96 as IPv4, which is what ::1 and friends are in the file. */
+			if p := ip.To16(); bytes.Equal(p[:12], make([]byte, 12)) {
+				ip = p[12:]
+			}
+			r, _ := read.Lookup(ip)
+			if got, _ := r.Value().(string); got != parts[1] {
+				t.Errorf("%v: %s => [%s] (%s)\n", tc.in, parts[0], got, parts[1])
+			}
+			if got, _ := mmdbLookup(t, db, net.ParseIP(parts[0])).(string); got != parts[1] {
+				t.Errorf("%v: %s in the tree => [%s] (%s)\n", tc.in, parts[0], got, parts[1])
+			}
+		}
+	}
+}
+
+/* Looks ip up the way an mmdb client does, with IPv4 under ::/96. */
+func mmdbLookup(t *testing.T, db []byte, ip net.IP) interface{} {
+	m, _, err := openMmdb(db)
+	if err != nil {
+		t.Fatalf("%s\n", err)
+	}
+	p := ip.To16()
+	if p4 := ip.To4(); p4 != nil {
+		p = append(make(net.IP, 12), p4...)
+	}
+	n := 0
+	for bit := 0; bit < 128; bit++ {
+		left, right := m.records(n)
+		if (p[bit/8]>>uint(7-bit%8))&1 == 1 {
+			left = right
+		}
+		if left == m.nodeCount {
+			return nil
+		} else if left > m.nodeCount {
+			v, _, err := m.decode(left - m.nodeCount - mmdbDataSep)
+			if err != nil {
+				t.Fatalf("%s: %s\n", ip, err)
+			}
+			return v
+		}
+		n = left
+	}
+	return nil
+}
+
+func TestMmdbBadData(t *testing.T) {
+	c, _ := InitCidr()
+	/* One node whose left record points between the tree and the data section. */
+	m := &mmdbReader{db: []byte{0, 0, 5, 0, 0, 1}, nodeCount: 1, recordSize: 24, cache: map[int]interface{}{}, visited: map[int]bool{}, table: c}
+	if err := m.walk(0, make(net.IP, 4), 0); err == nil {
+		t.Errorf("record in the separator: no error\n")
+	}
+	for _, data := range [][]byte{
+		{0x20, 0x00},                   /* A pointer to itself. */
+		{0x20, 0x02, 0x20, 0x00},       /* A pointer to a pointer. */
+		{0xe1, 0x41, 0x61, 0x20, 0x00}, /* A map whose value points back at the map. */
+	} {
+		m := &mmdbReader{data: data}
+		if _, _, err := m.decode(0); err == nil {
+			t.Errorf("% x: no error\n", data)
+		}
+	}
+	if _, _, err := (&mmdbReader{data: []byte{0x40}}).decode(-3); err == nil {
+		t.Errorf("negative offset: no error\n")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		in         string
+		start, end string
+		cidrs      string
+		size       string
+	}{
+		{"10.0.0.0/22", "10.0.0.0", "10.0.3.255", "10.0.0.0/22", "1024"},
+		{"10.0.0.1-10.0.0.10", "10.0.0.1", "10.0.0.10", "10.0.0.1/32 10.0.0.2/31 10.0.0.4/30 10.0.0.8/31 10.0.0.10/32", "10"},
+		{"192.0.2.10 - 20", "192.0.2.10", "192.0.2.20", "192.0.2.10/31 192.0.2.12/30 192.0.2.16/30 192.0.2.20/32", "11"},
+		{"0.0.0.0-255.255.255.255", "0.0.0.0", "255.255.255.255", "0.0.0.0/0", "4294967296"},
+		{"2001:db8::-2001:db8::2:ffff", "2001:db8::", "2001:db8::2:ffff", "2001:db8::/111 2001:db8::2:0/112", "196608"},
+		{"::ffff:10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1/32", "1"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0", "10.255.255.255", "10.0.0.0/8", "16777216"},
+	}
+	for _, c := range cases {
+		r, err := ParseRange(c.in)
+		if err != nil {
+			t.Errorf("[%s]: %s\n", c.in, err)
+			continue
+		}
+		var cidrs []string
+		for _, cidr := range r.Cidrs() {
+			cidrs = append(cidrs, cidr.String())
+		}
+		got := []string{r.Start().String(), r.End().String(), strings.Join(cidrs, " "), r.Size().String()}
+		want := []string{c.start, c.end, c.cidrs, c.size}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("[%s] = %q (%q)\n", c.in, got, want)
+		}
+	}
+
+	for _, in := range []string{"10.0.0.10-10.0.0.1", "10.0.0.1-2001:db8::1", "10.0.0.1-300", "bogus", "10.0.0.0/33"} {
+		if r, err := ParseRange(in); err == nil {
+			t.Errorf("[%s] = %s, want error\n", in, r)
+		}
+	}
+	if cidrs, err := RangeToCidrs(net.ParseIP("10.0.0.0"), net.ParseIP("10.0.1.255")); err != nil || len(cidrs) != 1 || cidrs[0].String() != "10.0.0.0/23" {
+		t.Errorf("RangeToCidrs(10.0.0.0, 10.0.1.255) = %v, %v\n", cidrs, err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		in    string
+		ones  int
+		n     int
+		count int
+		first string
+		last  string
+	}{
+		{"10.0.0.0/16", 20, 0, 16, "10.0.0.0/20", "10.0.240.0/20"},
+		{"10.0.0.77/24", 26, 0, 4, "10.0.0.0/26", "10.0.0.192/26"},
+		{"10.0.0.0/24", 24, 0, 1, "10.0.0.0/24", "10.0.0.0/24"},
+		{"10.0.0.0/16", 0, 6, 8, "10.0.0.0/19", "10.0.224.0/19"},
+		{"2001:db8::/32", 0, 4, 4, "2001:db8::/34", "2001:db8:c000::/34"},
+		{"255.255.255.0/24", 25, 0, 2, "255.255.255.0/25", "255.255.255.128/25"},
+		{"::ffff:10.0.0.0/120", 122, 0, 4, "10.0.0.0/26", "10.0.0.192/26"},
+		{"::ffff:10.0.0.0/112", 0, 3, 4, "10.0.0.0/18", "10.0.192.0/18"},
+	}
+	for _, c := range cases {
+		_, prefix, _ := net.ParseCIDR(c.in)
+		var subnets []net.IPNet
+		var err error
+		if c.n > 0 {
+			subnets, err = SplitN(*prefix, c.n)
+		} else {
+			subnets, err = Split(*prefix, c.ones)
+		}
+		if err != nil {
+			t.Errorf("[%s]: %s\n", c.in, err)
+		} else if len(subnets) != c.count || subnets[0].String() != c.first || subnets[len(subnets)-1].String() != c.last {
+			t.Errorf("[%s] /%d n=%d = %d %s..%s (%d %s..%s)\n", c.in, c.ones, c.n, len(subnets),
+				subnets[0].String(), subnets[len(subnets)-1].String(), c.count, c.first, c.last)
+		}
+	}
+
+	_, prefix, _ := net.ParseCIDR("10.0.0.0/16")
+	for _, ones := range []int{15, 33, 40} {
+		if _, err := Split(*prefix, ones); err == nil {
+			t.Errorf("Split(%s, %d), want error\n", prefix, ones)
+		}
+	}
+}
+
+func TestSupernet(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"10.0.0.0/24", "10.0.3.7"}, "10.0.0.0/22"},
+		{[]string{"10.0.0.1"}, "10.0.0.1/32"},
+		{[]string{"192.168.1.0/24", "192.168.2.0/24"}, "192.168.0.0/22"},
+		{[]string{"10.0.0.0/8", "11.0.0.0/8"}, "10.0.0.0/7"},
+		{[]string{"1.0.0.0", "255.0.0.0"}, "0.0.0.0/0"},
+		{[]string{"2001:db8::1", "2001:db8:0:1::1"}, "2001:db8::/63"},
+	}
+	for _, c := range cases {
+		var ranges []IpRange
+		for _, in := range c.in {
+			r, err := ParseRange(in)
+			if err != nil {
+				t.Fatalf("ParseRange(%s): %s\n", in, err)
+			}
+			ranges = append(ranges, r)
+		}
+		got, err := Supernet(ranges)
+		if err != nil || got.String() != c.want {
+			t.Errorf("%v = %s, %v (%s)\n", c.in, got.String(), err, c.want)
+		}
+	}
+
+	a, _ := ParseRange("10.0.0.0/8")
+	b, _ := ParseRange("2001:db8::/32")
+	if _, err := Supernet([]IpRange{a, b}); err == nil {
+		t.Errorf("Supernet across families, want error\n")
+	}
+}
+
+func TestPlanVLSM(t *testing.T) {
+	reqs, err := ReadRequirements(strings.NewReader("# site 12\nweb: 500 hosts, db: 60 hosts\nmgmt 10\nlink: 2 hosts\nvoip: 60\n"))
+	if err != nil {
+		t.Fatalf("ReadRequirements: %s\n", err)
+	}
+	_, parent, _ := net.ParseCIDR("10.20.0.0/22")
+	p, err := PlanVLSM(*parent, reqs)
+	if err != nil {
+		t.Fatalf("PlanVLSM: %s\n", err)
+	}
+	var got []string
+	for _, a := range p.Allocations {
+		got = append(got, fmt.Sprintf("%s=%s/%s", a.Name, a.Prefix.String(), a.Usable))
+	}
+	want := "web=10.20.0.0/23/510 db=10.20.2.0/26/62 voip=10.20.2.64/26/62 mgmt=10.20.2.128/28/14 link=10.20.2.144/30/2"
+	if strings.Join(got, " ") != want {
+		t.Errorf("plan = [%s] (%s)\n", strings.Join(got, " "), want)
+	}
+	var free []string
+	for _, f := range p.Free {
+		free = append(free, f.String())
+	}
+	if strings.Join(free, " ") != "10.20.2.148/30 10.20.2.152/29 10.20.2.160/27 10.20.2.192/26 10.20.3.0/24" {
+		t.Errorf("free = %v\n", free)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Table().WriteCidrList(&buf, nil); err != nil {
+		t.Fatalf("WriteCidrList: %s\n", err)
+	}
+	back, _ := InitCidr()
+	if err := back.ReadCidrList(&buf, nil); err != nil {
+		t.Fatalf("ReadCidrList: %s\n", err)
+	}
+	if r, ok := back.Lookup(net.ParseIP("10.20.2.100")); !ok || r.Value() != "voip" {
+		t.Errorf("10.20.2.100 = %v %v, want voip\n", r.Value(), ok)
+	}
+	if !strings.Contains(p.String(), "Allocated 660 of 1024 addresses (64.5%).") {
+		t.Errorf("plan text missing totals:\n%s", p.String())
+	}
+
+	/* An IPv4-mapped parent is planned as IPv4. */
+	_, mapped, _ := net.ParseCIDR("::ffff:10.20.0.0/118")
+	if mp, err := PlanVLSM(*mapped, reqs); err != nil {
+		t.Errorf("PlanVLSM(%s): %s\n", mapped, err)
+	} else if mp.Parent.String() != "10.20.0.0/22" || mp.Allocations[0].Prefix.String() != "10.20.0.0/23" {
+		t.Errorf("PlanVLSM(%s) = %s, %s\n", mapped, mp.Parent.String(), mp.Allocations[0].Prefix.String())
+	}
+
+	if _, err := PlanVLSM(*parent, []Requirement{{"a", 600}, {"b", 600}}); err == nil {
+		t.Errorf("two /22s' worth in a /22, want error\n")
+	}
+	if _, err := ReadRequirements(strings.NewReader("a: 1\na: 2\n")); err == nil {
+		t.Errorf("duplicate names, want error\n")
+	}
+	_, parent6, _ := net.ParseCIDR("2001:db8::/120")
+	if p, err := PlanVLSM(*parent6, []Requirement{{"a", 100}, {"b", 1}}); err != nil || p.Allocations[0].Prefix.String() != "2001:db8::/121" || p.Allocations[1].Prefix.String() != "2001:db8::80/128" {
+		t.Errorf("IPv6 plan = %v, %v\n", p, err)
+	}
+}
+
+func TestPlanDelegation(t *testing.T) {
+	_, parent, _ := net.ParseCIDR("2001:db8:abcd::/48")
+	levels, err := ParseScheme("site:8,vlan:8")
+	if err != nil {
+		t.Fatalf("ParseScheme: %s\n", err)
+	}
+	entries, err := ReadDelegations(strings.NewReader("nyc 1\nnyc/users 0x10\nnyc/voice\nlon 0x2a\nlon/users\n"))
+	if err != nil {
+		t.Fatalf("ReadDelegations: %s\n", err)
+	}
+	p, err := PlanDelegation(*parent, levels, entries)
+	if err != nil {
+		t.Fatalf("PlanDelegation: %s\n", err)
+	}
+	var got []string
+	for _, d := range p.Delegations {
+		got = append(got, d.Name+"="+d.Prefix.String())
+	}
+	want := "nyc=2001:db8:abcd:100::/56 nyc/users=2001:db8:abcd:110::/64 nyc/voice=2001:db8:abcd:111::/64 lon=2001:db8:abcd:2a00::/56 lon/users=2001:db8:abcd:2a00::/64"
+	if strings.Join(got, " ") != want {
+		t.Errorf("plan = [%s] (%s)\n", strings.Join(got, " "), want)
+	}
+	if zone := ReverseZone(p.Delegations[0].Prefix); zone != "1.0.d.c.b.a.8.b.d.0.1.0.0.2.ip6.arpa" {
+		t.Errorf("ReverseZone(%s) = [%s]\n", p.Delegations[0].Prefix.String(), zone)
+	}
+
+	d, ids, ok := p.Find(net.ParseIP("2001:db8:abcd:111::53"))
+	if !ok || d.Name != "nyc/voice" || fmt.Sprint(ids) != "[1 17]" {
+		t.Errorf("Find = %s %v %v\n", d.Name, ids, ok)
+	}
+	if r, ok := p.Table().Lookup(net.ParseIP("2001:db8:abcd:1ff::1")); !ok || r.Value() != "nyc" {
+		t.Errorf("Table lookup = %v %v, want nyc\n", r.Value(), ok)
+	}
+
+	/* Levels only ever widen: 6 then 8 bits under a /48 become /56 and /64, 6 then 10 become /56 and /68. */
+	if aligned := NibbleAlign(48, []Level{{"site", 6}, {"vlan", 8}}); aligned[0].Bits != 8 || aligned[1].Bits != 8 {
+		t.Errorf("NibbleAlign(6, 8) = %v\n", aligned)
+	}
+	if aligned := NibbleAlign(48, []Level{{"site", 6}, {"vlan", 10}}); aligned[0].Bits != 8 || aligned[1].Bits != 12 {
+		t.Errorf("NibbleAlign(6, 10) = %v\n", aligned)
+	}
+
+	bad := []string{"nyc/users 1\n", "nyc 1\nlon 1\n", "nyc 256\n", "a 1\na/b 1\na/b/c 1\n"}
+	for _, in := range bad {
+		entries, _ := ReadDelegations(strings.NewReader(in))
+		if _, err := PlanDelegation(*parent, levels, entries); err == nil {
+			t.Errorf("[%q], want error\n", in)
+		}
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	collect := func(it *Addresses) string {
+		var ips []string
+		for it.Next() {
+			ips = append(ips, it.Ip().String())
+		}
+		if it.Err() != nil {
+			ips = append(ips, it.Err().Error())
+		}
+		return strings.Join(ips, " ")
+	}
+	cases := []struct {
+		in   []string
+		o    *ExpandOptions
+		want string
+	}{
+		{[]string{"10.0.0.0/30"}, nil, "10.0.0.0 10.0.0.1 10.0.0.2 10.0.0.3"},
+		{[]string{"10.0.0.0/29", "10.0.1.0/30"}, &ExpandOptions{Every: 3}, "10.0.0.0 10.0.0.3 10.0.0.6 10.0.1.1"},
+		{[]string{"10.0.0.0/24"}, &ExpandOptions{Every: 100}, "10.0.0.0 10.0.0.100 10.0.0.200"},
+		{[]string{"10.0.0.0/30"}, &ExpandOptions{Limit: 4}, "10.0.0.0 10.0.0.1 10.0.0.2 10.0.0.3"},
+		{[]string{"10.0.0.0/30"}, &ExpandOptions{Limit: 2}, "10.0.0.0 10.0.0.1 " + ErrLimit.Error()},
+		{[]string{"255.255.255.254/31"}, nil, "255.255.255.254 255.255.255.255"},
+		{[]string{"2001:db8::/126"}, nil, "2001:db8:: 2001:db8::1 2001:db8::2 2001:db8::3"},
+		{[]string{"2001:db8::/32"}, &ExpandOptions{Every: 1 << 62, Limit: 3}, "2001:db8:: 2001:db8:0:0:4000:: 2001:db8:0:0:8000:: " + ErrLimit.Error()},
+	}
+	for _, c := range cases {
+		if got := collect(loadTable(t, c.in...).Addresses(c.o)); got != c.want {
+			t.Errorf("%v %+v = [%s] (%s)\n", c.in, c.o, got, c.want)
+		}
+	}
+
+	hosts, _ := InitCidr()
+	for _, cidr := range []string{"10.0.0.0/30", "10.0.0.4/30", "10.0.0.8/31", "::ffff:10.0.1.0/126", "2001:db8::/127"} {
+		_, prefix, _ := net.ParseCIDR(cidr)
+		if err := hosts.AddHosts(*prefix); err != nil {
+			t.Errorf("AddHosts(%s): %s\n", cidr, err)
+		}
+	}
+	want := "10.0.0.1 10.0.0.2 10.0.0.5 10.0.0.6 10.0.0.8 10.0.0.9 10.0.1.1 10.0.1.2 2001:db8:: 2001:db8::1"
+	if got := collect(hosts.Addresses(nil)); got != want {
+		t.Errorf("hosts = [%s] (%s)\n", got, want)
+	}
+
+	it := loadTable(t, "2001:db8::/64").Addresses(nil)
+	for it.Next() {
+	}
+	if it.Err() != ErrLimit || it.Count() != DefaultIpv6Limit {
+		t.Errorf("/64 stopped after %d: %v\n", it.Count(), it.Err())
+	}
+	r, _ := ParseRange("10.0.0.5-10.0.0.7")
+	if got := collect(r.Addresses(nil)); got != "10.0.0.5 10.0.0.6 10.0.0.7" {
+		t.Errorf("range = [%s]\n", got)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	cases := []struct {
+		in      []string
+		exclude []string
+		want    string /* Every address that can come out, in order. */
+	}{
+		{[]string{"10.0.0.0/30"}, nil, "10.0.0.0 10.0.0.1 10.0.0.2 10.0.0.3"},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.1"}, "10.0.0.0 10.0.0.2 10.0.0.3"},
+		{[]string{"10.0.0.0/30", "10.0.0.8/30"}, []string{"10.0.0.2/31", "10.0.0.8/31", "10.0.0.11"}, "10.0.0.0 10.0.0.1 10.0.0.10"},
+		{[]string{"10.0.0.0/30", "2001:db8::/127"}, []string{"10.0.0.0/31", "10.0.0.3"}, "10.0.0.2 2001:db8:: 2001:db8::1"},
+		{[]string{"255.255.255.254/31"}, []string{"255.255.255.254"}, "255.255.255.255"},
+	}
+	for _, c := range cases {
+		var exclude *CidrTable
+		if c.exclude != nil {
+			exclude = loadTable(t, c.exclude...)
+		}
+		g, err := loadTable(t, c.in...).Random(exclude, 1)
+		if err != nil {
+			t.Errorf("%v - %v: %s\n", c.in, c.exclude, err)
+			continue
+		}
+		seen, _ := InitCidr()
+		for i := 0; i < 200; i++ {
+			seen.AddCidr(g.Next().String())
+		}
+		var got []string
+		for it := seen.Addresses(nil); it.Next(); {
+			got = append(got, it.Ip().String())
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%v - %v => [%s] (%s)\n", c.in, c.exclude, strings.Join(got, " "), c.want)
+		}
+	}
+
+	/* The same seed gives the same addresses. */
+	r, _ := ParseRange("2001:db8::/32")
+	a, _ := r.Random(nil, 42)
+	b, _ := r.Random(nil, 42)
+	for i := 0; i < 10; i++ {
+		if x, y := a.Next(), b.Next(); !x.Equal(y) {
+			t.Errorf("seeded draw %d: %s != %s\n", i, x, y)
+		}
+	}
+	if g, err := r.Random(loadTable(t, "2001:db8::/31"), 1); err == nil {
+		t.Errorf("random from an excluded range = %v\n", g.Size())
+	}
+}
@@ -0,0 +1,295 @@
+package cidrtable
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yargevad/net/ip/convert"
+)
+
+/* One level of an IPv6 addressing scheme, e.g. 8 bits of site ID under a /48. */
+type Level struct {
+	Name string
+	Bits int
+}
+
+/* Parses a scheme written "site:8,vlan:8", outermost level first. */
+func ParseScheme(s string) ([]Level, error) {
+	var levels []Level
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("bad scheme level [%s], want name:bits", part)
+		}
+		bits, err := strconv.Atoi(fields[1])
+		if err != nil || bits < 1 || bits > 64 {
+			return nil, fmt.Errorf("bad scheme level [%s], want 1-64 bits", part)
+		}
+		levels = append(levels, Level{Name: fields[0], Bits: bits})
+	}
+	return levels, nil
+}
+
+/*
+ * Widens levels so each one ends on a nibble (4-bit) boundary below a
+ * parent of the given length, keeping ip6.arpa zones one per prefix.
+ */
+func NibbleAlign(parentOnes int, levels []Level) []Level {
+	aligned := make([]Level, len(levels))
+	at := parentOnes
+	for i, l := range levels {
+		end := (at + l.Bits + 3) &^ 3
+		aligned[i] = Level{Name: l.Name, Bits: end - at}
+		at = end
+	}
+	return aligned
+}
+
+/* Returns the prefix for ids (outermost first, one per level used) within parent. */
+func EncodePrefix(parent net.IPNet, levels []Level, ids []uint64) (net.IPNet, error) {
+	ones, bits := parent.Mask.Size()
+	if bits != 128 {
+		return net.IPNet{}, fmt.Errorf("not an IPv6 prefix [%s]", parent.String())
+	} else if len(ids) > len(levels) {
+		return net.IPNet{}, fmt.Errorf("%d ids for a %d-level scheme", len(ids), len(levels))
+	}
+	n := new(big.Int).SetBytes(parent.IP.To16().Mask(parent.Mask))
+	at := ones
+	for i, id := range ids {
+		l := levels[i]
+		if at+l.Bits > 128 {
+			return net.IPNet{}, fmt.Errorf("scheme runs past 128 bits at level [%s]", l.Name)
+		} else if l.Bits < 64 && id >= 1<<uint(l.Bits) {
+			return net.IPNet{}, fmt.Errorf("%s id %d doesn't fit in %d bits", l.Name, id, l.Bits)
+		}
+		at += l.Bits
+		n.Or(n, new(big.Int).Lsh(new(big.Int).SetUint64(id), uint(128-at)))
+	}
+	ip := make(net.IP, net.IPv6len)
+	b := n.Bytes()
+	copy(ip[len(ip)-len(b):], b)
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(at, 128)}, nil
+}
+
+/* Reads back the id at every level from an address within parent. */
+func DecodePrefix(parent net.IPNet, levels []Level, ip net.IP) ([]uint64, error) {
+	ones, _ := parent.Mask.Size()
+	if ip.To4() != nil || !parent.Contains(ip) {
+		return nil, fmt.Errorf("[%s] isn't in [%s]", ip, parent.String())
+	}
+	n := new(big.Int).SetBytes(ip.To16())
+	var ids []uint64
+	at := ones
+	for _, l := range levels {
+		if at+l.Bits > 128 {
+			break
+		}
+		at += l.Bits
+		mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(l.Bits)), big.NewInt(1))
+		ids = append(ids, new(big.Int).And(new(big.Int).Rsh(n, uint(128-at)), mask).Uint64())
+	}
+	return ids, nil
+}
+
+/* A named prefix to delegate: Name is a path like "nyc/users", one part per level. */
+type DelegationEntry struct {
+	Name string
+	Id   uint64
+	Auto bool /* Instead of Id, take the first free id after the sibling before it (0 for the first). */
+}
+
+type Delegation struct {
+	Name   string
+	Level  string
+	Ids    []uint64 /* The id at each level down to this one. */
+	Prefix net.IPNet
+}
+
+type DelegationPlan struct {
+	Parent      net.IPNet
+	Levels      []Level
+	Delegations []Delegation /* In entry order, each after its parent. */
+}
+
+/*
+ * Works out every entry's prefix. Each entry's parent path must come
+ * earlier; ids must be unique among siblings.
+ */
+func PlanDelegation(parent net.IPNet, levels []Level, entries []DelegationEntry) (*DelegationPlan, error) {
+	if _, bits := parent.Mask.Size(); bits != 128 {
+		return nil, fmt.Errorf("not an IPv6 prefix [%s]", parent.String())
+	}
+	p := &DelegationPlan{Parent: parent, Levels: levels}
+	p.Parent.IP = parent.IP.To16().Mask(parent.Mask)
+	byName := map[string][]uint64{"": nil}
+	used := map[string]bool{}
+	next := map[string]uint64{}
+
+	for _, e := range entries {
+		depth := strings.Count(e.Name, "/")
+		parentName := ""
+		if depth > 0 {
+			parentName = e.Name[:strings.LastIndexByte(e.Name, '/')]
+		}
+		parentIds, ok := byName[parentName]
+		if !ok {
+			return nil, fmt.Errorf("[%s] comes before its parent [%s]", e.Name, parentName)
+		} else if _, dup := byName[e.Name]; dup {
+			return nil, fmt.Errorf("duplicate entry [%s]", e.Name)
+		} else if depth >= len(levels) {
+			return nil, fmt.Errorf("[%s] is deeper than the %d-level scheme", e.Name, len(levels))
+		}
+
+		/* Sibling ids are keyed by the parent's name. */
+		key := func(id uint64) string { return fmt.Sprintf("%s#%d", parentName, id) }
+		id := e.Id
+		if e.Auto {
+			id = next[parentName]
+			for used[key(id)] {
+				id++
+			}
+		}
+		if used[key(id)] {
+			return nil, fmt.Errorf("[%s] reuses %s id %d", e.Name, levels[depth].Name, id)
+		}
+		ids := append(append([]uint64{}, parentIds...), id)
+		prefix, err := EncodePrefix(p.Parent, levels, ids)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %s", e.Name, err)
+		}
+		used[key(id)] = true
+		next[parentName] = id + 1
+		byName[e.Name] = ids
+		p.Delegations = append(p.Delegations, Delegation{Name: e.Name, Level: levels[depth].Name, Ids: ids, Prefix: prefix})
+	}
+	return p, nil
+}
+
+/* Returns the ip6.arpa zone for a prefix on a nibble boundary, or "" for one that isn't. */
+func ReverseZone(prefix net.IPNet) string {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || ones%4 != 0 {
+		return ""
+	}
+	name := convert.ReverseName(prefix.IP)
+	/* Each nibble is two characters ("x."), ahead of "ip6.arpa". */
+	return name[2*(32-ones/4):]
+}
+
+/* Lays the plan out as a table of names, ids, prefixes and reverse zones. */
+func (p *DelegationPlan) String() string {
+	var buf bytes.Buffer
+	var scheme []string
+	at, _ := p.Parent.Mask.Size()
+	for _, l := range p.Levels {
+		at += l.Bits
+		scheme = append(scheme, fmt.Sprintf("%s /%d", l.Name, at))
+	}
+	fmt.Fprintf(&buf, "Parent: %s (%s)\n\n", p.Parent.String(), strings.Join(scheme, ", "))
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLEVEL\tID\tPREFIX\tREVERSE ZONE")
+	for _, d := range p.Delegations {
+		id := d.Ids[len(d.Ids)-1]
+		fmt.Fprintf(tw, "%s\t%s\t%d (0x%x)\t%s\t%s\n", d.Name, d.Level, id, id, d.Prefix.String(), ReverseZone(d.Prefix))
+	}
+	tw.Flush()
+	return buf.String()
+}
+
+/* Writes each prefix and its name, parents first, in the format LoadCidrList reads. */
+func (p *DelegationPlan) WriteCidrList(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, d := range p.Delegations {
+		fmt.Fprintf(bw, "%s\t%s\n", d.Prefix.String(), d.Name)
+	}
+	return bw.Flush()
+}
+
+/* Returns the plan as a table, where each address maps to the most specific name covering it. */
+func (p *DelegationPlan) Table() *CidrTable {
+	c, _ := InitCidr()
+	for depth := 0; depth < len(p.Levels); depth++ {
+		for _, d := range p.Delegations {
+			if len(d.Ids) == depth+1 {
+				c.AddCidrValue(d.Prefix.String(), d.Name)
+			}
+		}
+	}
+	return c
+}
+
+/* Loads delegation entries from a file; see ReadDelegations. */
+func LoadDelegations(path string) ([]DelegationEntry, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	entries, err := ReadDelegations(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return entries, nil
+}
+
+/*
+ * Reads entries written "path [id]", one per line: "nyc 1", "nyc/users 0x10".
+ * Ids may be decimal or 0x hex; without one, the entry takes the first free
+ * id after its previous sibling's.
+ * Blank lines and "#" comments are skipped.
+ */
+func ReadDelegations(r io.Reader) ([]DelegationEntry, error) {
+	var entries []DelegationEntry
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexRune(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			entries = append(entries, DelegationEntry{Name: fields[0], Auto: true})
+		case 2:
+			id, err := strconv.ParseUint(fields[1], 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad id [%s]", lineNo, fields[1])
+			}
+			entries = append(entries, DelegationEntry{Name: fields[0], Id: id})
+		default:
+			return nil, fmt.Errorf("line %d: want \"path [id]\", not [%s]", lineNo, line)
+		}
+		if name := entries[len(entries)-1].Name; strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+			return nil, fmt.Errorf("line %d: bad path [%s]", lineNo, name)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+/* Names the most specific delegation containing ip, with the ids decoded from it. */
+func (p *DelegationPlan) Find(ip net.IP) (Delegation, []uint64, bool) {
+	ids, err := DecodePrefix(p.Parent, p.Levels, ip)
+	if err != nil {
+		return Delegation{}, nil, false
+	}
+	var best Delegation
+	found := false
+	for _, d := range p.Delegations {
+		if d.Prefix.Contains(ip) && (!found || len(d.Ids) > len(best.Ids)) {
+			best, found = d, true
+		}
+	}
+	return best, ids, found
+}
@@ -0,0 +1,169 @@
+package cidrtable
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* The cap on addresses from an expansion touching IPv6 when ExpandOptions.Limit is 0. */
+const DefaultIpv6Limit = 1 << 20
+
+/* Returned by Addresses.Err when the limit stopped the expansion before the end. */
+var ErrLimit = errors.New("address limit reached")
+
+/* Controls what Addresses yields. The zero value yields every address, up to DefaultIpv6Limit for IPv6. */
+type ExpandOptions struct {
+	Every uint64 /* Yield only every Nth address, 0 or 1 for all of them. */
+	Limit uint64 /* Stop after this many addresses, 0 for no limit (DefaultIpv6Limit for IPv6). */
+}
+
+/*
+ * Adds the host addresses of prefix to the table: all of it for IPv6 and
+ * IPv4 /31s and /32s, less the network and broadcast addresses for other
+ * IPv4 blocks. Add each prefix this way before expanding the table, since
+ * once blocks merge their ends can't be told apart from the addresses
+ * between them.
+ */
+func (c *CidrTable) AddHosts(prefix net.IPNet) error {
+	start, ones, bits := normalizeNet(prefix)
+	if start == nil {
+		return fmt.Errorf("bad prefix [%s]", prefix.String())
+	}
+	end := arith.Last(start, ones)
+	if bits == 32 && ones < 31 {
+		start, _ = arith.Next(start)
+		end, _ = arith.Prev(end)
+	}
+	c.insert(start, end, nil)
+	return nil
+}
+
+/*
+ * Loads a CIDR list like LoadCidrList, but adds each block with AddHosts,
+ * so IPv4 blocks lose their network and broadcast addresses before they
+ * merge. Labels only serve Name; the ranges carry no value.
+ */
+func (c *CidrTable) LoadHostList(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadHostList)
+}
+
+func (c *CidrTable) ReadHostList(r io.Reader, f *ImportFilter) error {
+	return readList(r, f, func(entry string, value interface{}) error {
+		if _, prefix, err := net.ParseCIDR(entry); err == nil {
+			return c.AddHosts(*prefix)
+		}
+		return c.AddCidr(entry)
+	})
+}
+
+/*
+ * Streams the addresses of a set of ranges, one at a time, like
+ * bufio.Scanner:
+ *
+ *   it := c.Addresses(nil)
+ *   for it.Next() {
+ *       use(it.Ip())
+ *   }
+ *   if err := it.Err(); err != nil { ... }
+ */
+type Addresses struct {
+	ranges  []IpRange /* Ranges not started yet. */
+	o       ExpandOptions
+	limit   uint64
+	cur     net.IP /* Next address in the current range, nil between ranges. */
+	last    net.IP /* Last address of the current range. */
+	skip    uint64 /* Addresses still to pass over before the next sample. */
+	emitted uint64
+	ip      net.IP
+	err     error
+}
+
+/* Streams every address in the table, in order. */
+func (c *CidrTable) Addresses(o *ExpandOptions) *Addresses {
+	return newAddresses(c.Ranges(), o)
+}
+
+/* Streams every address in the range, in order. */
+func (r IpRange) Addresses(o *ExpandOptions) *Addresses {
+	return newAddresses([]IpRange{r}, o)
+}
+
+func newAddresses(ranges []IpRange, o *ExpandOptions) *Addresses {
+	it := &Addresses{ranges: ranges}
+	if o != nil {
+		it.o = *o
+	}
+	it.limit = it.o.Limit
+	if it.limit == 0 {
+		for _, r := range ranges {
+			if len(r.start) == net.IPv6len {
+				it.limit = DefaultIpv6Limit
+				break
+			}
+		}
+	}
+	return it
+}
+
+/* Moves to the next address, returning false at the end, at the limit or on error. */
+func (it *Addresses) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		if it.cur == nil && !it.nextBlock() {
+			return false
+		}
+		/* Pass over whatever sampling skips, a range at a time if need be. */
+		if it.skip > 0 {
+			left, _ := arith.Distance(it.cur, it.last)
+			if left.Cmp(new(big.Int).SetUint64(it.skip)) < 0 {
+				it.skip -= left.Uint64() + 1
+				it.cur = nil
+				continue
+			}
+			it.cur, _ = arith.Add(it.cur, new(big.Int).SetUint64(it.skip))
+			it.skip = 0
+		}
+
+		if it.limit > 0 && it.emitted == it.limit {
+			it.err = ErrLimit
+			return false
+		}
+		it.ip = it.cur
+		it.emitted++
+		if it.o.Every > 1 {
+			it.skip = it.o.Every - 1
+		}
+		if arith.Compare(it.cur, it.last) >= 0 {
+			it.cur = nil
+		} else {
+			it.cur, _ = arith.Next(it.cur)
+		}
+		return true
+	}
+}
+
+/* Moves on to the next range; false when there are none left. */
+func (it *Addresses) nextBlock() bool {
+	if len(it.ranges) == 0 {
+		return false
+	}
+	it.cur, it.last = it.ranges[0].start, it.ranges[0].end
+	it.ranges = it.ranges[1:]
+	return true
+}
+
+/* The current address; valid until the next call to Next. */
+func (it *Addresses) Ip() net.IP { return it.ip }
+
+/* How many addresses Next has yielded. */
+func (it *Addresses) Count() uint64 { return it.emitted }
+
+/* ErrLimit if the limit cut the expansion short, otherwise nil. */
+func (it *Addresses) Err() error { return it.err }
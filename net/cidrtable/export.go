@@ -0,0 +1,246 @@
+package cidrtable
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+/* Default chunk sizes, for formats that limit how many entries fit in one set/statement/group. */
+const (
+	NftablesChunk = 1000  /* Elements per "add element" statement. */
+	IpsetChunk    = 65536 /* Default ipset maxelem. */
+	AwsChunk      = 60    /* Default inbound rules per security group. */
+)
+
+/* Controls how the Write* exporters render a table. Zero values pick sensible defaults. */
+type ExportOptions struct {
+	Name      string /* Chain, set, table or security group name. */
+	Target    string /* iptables jump target. */
+	ChunkSize int    /* Max entries per set, statement or group. */
+	Protocol  string /* AWS IpProtocol, "-1" for all traffic. */
+	FromPort  int    /* AWS port range, ignored for protocol "-1". */
+	ToPort    int
+}
+
+func (o *ExportOptions) name(def string) string {
+	if o == nil || o.Name == "" {
+		return def
+	}
+	return o.Name
+}
+
+func (o *ExportOptions) chunk(def int) int {
+	if o == nil || o.ChunkSize <= 0 {
+		return def
+	}
+	return o.ChunkSize
+}
+
+/* Splits the table's CIDR blocks by address family. */
+func (c *CidrTable) familyCidrs() (v4, v6 []net.IPNet) {
+	for _, cidr := range c.Cidrs() {
+		if len(cidr.IP) == net.IPv4len {
+			v4 = append(v4, cidr)
+		} else {
+			v6 = append(v6, cidr)
+		}
+	}
+	return v4, v6
+}
+
+func chunkCidrs(cidrs []net.IPNet, size int) [][]net.IPNet {
+	var chunks [][]net.IPNet
+	for len(cidrs) > size {
+		chunks = append(chunks, cidrs[:size])
+		cidrs = cidrs[size:]
+	}
+	if len(cidrs) > 0 {
+		chunks = append(chunks, cidrs)
+	}
+	return chunks
+}
+
+/* Names each chunk after the base name, adding a suffix only when there's more than one. */
+func chunkName(base string, i, count int) string {
+	if count <= 1 {
+		return base
+	}
+	return fmt.Sprintf("%s-%d", base, i)
+}
+
+/*
+ * Writes every block, one per line, followed by its range's value (if any)
+ * as a label: the format LoadCidrList reads back.
+ */
+func (c *CidrTable) WriteCidrList(w io.Writer, o *ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range c.Ranges() {
+		for _, cidr := range r.Cidrs() {
+			if r.value != nil {
+				fmt.Fprintf(bw, "%s\t%v\n", cidr.String(), r.value)
+			} else {
+				fmt.Fprintln(bw, cidr.String())
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+/* Writes the IPv4 blocks as an iptables-restore file. */
+func (c *CidrTable) WriteIptables(w io.Writer, o *ExportOptions) error {
+	v4, _ := c.familyCidrs()
+	return writeIptables(w, v4, o)
+}
+
+/* Writes the IPv6 blocks as an ip6tables-restore file. */
+func (c *CidrTable) WriteIp6tables(w io.Writer, o *ExportOptions) error {
+	_, v6 := c.familyCidrs()
+	return writeIptables(w, v6, o)
+}
+
+func writeIptables(w io.Writer, cidrs []net.IPNet, o *ExportOptions) error {
+	chain := o.name("CIDRTABLE")
+	target := "ACCEPT"
+	if o != nil && o.Target != "" {
+		target = o.Target
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*filter\n:%s - [0:0]\n-F %s\n", chain, chain)
+	for _, cidr := range cidrs {
+		fmt.Fprintf(bw, "-A %s -s %s -j %s\n", chain, cidr.String(), target)
+	}
+	fmt.Fprintf(bw, "COMMIT\n")
+	return bw.Flush()
+}
+
+/* Writes an nft script defining one interval set per family, in an inet table. */
+func (c *CidrTable) WriteNftables(w io.Writer, o *ExportOptions) error {
+	name := o.name("cidrtable")
+	size := o.chunk(NftablesChunk)
+	v4, v6 := c.familyCidrs()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "table inet %s {\n", name)
+	fmt.Fprintf(bw, "\tset %s4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n", name)
+	fmt.Fprintf(bw, "\tset %s6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t}\n", name)
+	fmt.Fprintf(bw, "}\n")
+	fmt.Fprintf(bw, "flush set inet %s %s4\n", name, name)
+	fmt.Fprintf(bw, "flush set inet %s %s6\n", name, name)
+	for _, family := range []struct {
+		set   string
+		cidrs []net.IPNet
+	}{{name + "4", v4}, {name + "6", v6}} {
+		for _, chunk := range chunkCidrs(family.cidrs, size) {
+			fmt.Fprintf(bw, "add element inet %s %s { %s }\n", name, family.set, joinCidrs(chunk, ", "))
+		}
+	}
+	return bw.Flush()
+}
+
+/*
+ * Writes an "ipset restore" file. Each family gets its own hash:net set, split into
+ * several sets of at most ChunkSize entries (named <name>4-0, <name>4-1, ...) if needed.
+ */
+func (c *CidrTable) WriteIpset(w io.Writer, o *ExportOptions) error {
+	name := o.name("cidrtable")
+	size := o.chunk(IpsetChunk)
+	v4, v6 := c.familyCidrs()
+	bw := bufio.NewWriter(w)
+	for _, family := range []struct {
+		set, inet string
+		cidrs     []net.IPNet
+	}{{name + "4", "inet", v4}, {name + "6", "inet6", v6}} {
+		chunks := chunkCidrs(family.cidrs, size)
+		for i, chunk := range chunks {
+			set := chunkName(family.set, i, len(chunks))
+			fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", set, family.inet, size)
+			fmt.Fprintf(bw, "flush %s\n", set)
+			for _, cidr := range chunk {
+				fmt.Fprintf(bw, "add %s %s\n", set, cidr.String())
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+/* Writes a pf.conf table definition holding both families. */
+func (c *CidrTable) WritePf(w io.Writer, o *ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "table <%s> persist {", o.name("cidrtable"))
+	for i, cidr := range c.Cidrs() {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		fmt.Fprintf(bw, " \\\n\t%s", cidr.String())
+	}
+	fmt.Fprintf(bw, " }\n")
+	return bw.Flush()
+}
+
+type awsIpRange struct {
+	CidrIp      string `json:"CidrIp"`
+	Description string `json:"Description,omitempty"`
+}
+
+type awsIpv6Range struct {
+	CidrIpv6    string `json:"CidrIpv6"`
+	Description string `json:"Description,omitempty"`
+}
+
+type awsIpPermission struct {
+	IpProtocol string         `json:"IpProtocol"`
+	FromPort   *int           `json:"FromPort,omitempty"`
+	ToPort     *int           `json:"ToPort,omitempty"`
+	IpRanges   []awsIpRange   `json:"IpRanges,omitempty"`
+	Ipv6Ranges []awsIpv6Range `json:"Ipv6Ranges,omitempty"`
+}
+
+type awsSecurityGroup struct {
+	GroupName     string            `json:"GroupName"`
+	IpPermissions []awsIpPermission `json:"IpPermissions"`
+}
+
+/*
+ * Writes a JSON list of security groups, each holding at most ChunkSize rules, whose
+ * IpPermissions can be passed to "aws ec2 authorize-security-group-ingress".
+ */
+func (c *CidrTable) WriteAwsSecurityGroups(w io.Writer, o *ExportOptions) error {
+	name := o.name("cidrtable")
+	perm := awsIpPermission{IpProtocol: "-1"}
+	if o != nil && o.Protocol != "" && o.Protocol != "-1" {
+		from, to := o.FromPort, o.ToPort
+		perm = awsIpPermission{IpProtocol: o.Protocol, FromPort: &from, ToPort: &to}
+	}
+
+	chunks := chunkCidrs(c.Cidrs(), o.chunk(AwsChunk))
+	groups := []awsSecurityGroup{}
+	for i, chunk := range chunks {
+		p := perm
+		for _, cidr := range chunk {
+			if len(cidr.IP) == net.IPv4len {
+				p.IpRanges = append(p.IpRanges, awsIpRange{CidrIp: cidr.String()})
+			} else {
+				p.Ipv6Ranges = append(p.Ipv6Ranges, awsIpv6Range{CidrIpv6: cidr.String()})
+			}
+		}
+		groups = append(groups, awsSecurityGroup{
+			GroupName:     chunkName(name, i, len(chunks)),
+			IpPermissions: []awsIpPermission{p},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(groups)
+}
+
+func joinCidrs(cidrs []net.IPNet, sep string) string {
+	strs := make([]string, len(cidrs))
+	for i, cidr := range cidrs {
+		strs[i] = cidr.String()
+	}
+	return strings.Join(strs, sep)
+}
@@ -0,0 +1,305 @@
+package cidrtable
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* Restricts which entries the Load* functions take from a source. Empty fields match everything. */
+type ImportFilter struct {
+	Service string /* AWS service, GCP service, Azure system service. */
+	Region  string /* AWS region, GCP scope, Azure region. */
+	Name    string /* Azure service tag, Cisco/Juniper prefix-list name, CIDR list label. */
+	Country string /* RIR country code. */
+	Status  string /* RIR status (allocated, assigned, ...). */
+}
+
+func (f *ImportFilter) match(field, want string) bool {
+	return want == "" || strings.EqualFold(field, want)
+}
+
+func (f *ImportFilter) service(s string) bool {
+	return f == nil || f.match(s, f.Service)
+}
+
+func (f *ImportFilter) region(s string) bool {
+	return f == nil || f.match(s, f.Region)
+}
+
+func (f *ImportFilter) name(s string) bool {
+	return f == nil || f.match(s, f.Name)
+}
+
+/* Opens path and hands it to read, naming the file in any error. */
+func (c *CidrTable) loadFile(path string, f *ImportFilter, read func(*CidrTable, io.Reader, *ImportFilter) error) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err = read(c, fh, f); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+/*
+ * Loads a plain list of CIDR blocks or IPs, one per line, each optionally
+ * followed by a label that becomes the range's value. Blank lines and
+ * "#" comments are skipped; Name limits the load to one label.
+ */
+func (c *CidrTable) LoadCidrList(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadCidrList)
+}
+
+func (c *CidrTable) ReadCidrList(r io.Reader, f *ImportFilter) error {
+	return readList(r, f, c.AddCidrValue)
+}
+
+/* Hands each entry of a CIDR list that passes f to add, with its label as the value. */
+func readList(r io.Reader, f *ImportFilter, add func(entry string, value interface{}) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexRune(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var value interface{}
+		label := strings.Join(fields[1:], " ")
+		if label != "" {
+			value = label
+		}
+		if !f.name(label) {
+			continue
+		}
+		if err := add(fields[0], value); err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+/* Loads an AWS ip-ranges.json file. */
+func (c *CidrTable) LoadAwsIpRanges(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadAwsIpRanges)
+}
+
+func (c *CidrTable) ReadAwsIpRanges(r io.Reader, f *ImportFilter) error {
+	var doc struct {
+		Prefixes []struct {
+			IpPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		Ipv6Prefixes []struct {
+			Ipv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	for _, p := range doc.Prefixes {
+		if f.service(p.Service) && f.region(p.Region) {
+			if err := c.AddCidr(p.IpPrefix); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range doc.Ipv6Prefixes {
+		if f.service(p.Service) && f.region(p.Region) {
+			if err := c.AddCidr(p.Ipv6Prefix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* Loads a GCP cloud.json (or goog.json) file. Region matches the prefix's scope. */
+func (c *CidrTable) LoadGcpCloud(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadGcpCloud)
+}
+
+func (c *CidrTable) ReadGcpCloud(r io.Reader, f *ImportFilter) error {
+	var doc struct {
+		Prefixes []struct {
+			Ipv4Prefix string `json:"ipv4Prefix"`
+			Ipv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	for _, p := range doc.Prefixes {
+		if !f.service(p.Service) || !f.region(p.Scope) {
+			continue
+		}
+		for _, cidr := range []string{p.Ipv4Prefix, p.Ipv6Prefix} {
+			if cidr == "" {
+				continue
+			}
+			if err := c.AddCidr(cidr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* Loads an Azure service tags JSON file. Name matches the service tag, e.g. "AzureCloud.eastus". */
+func (c *CidrTable) LoadAzureServiceTags(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadAzureServiceTags)
+}
+
+func (c *CidrTable) ReadAzureServiceTags(r io.Reader, f *ImportFilter) error {
+	var doc struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	for _, v := range doc.Values {
+		p := v.Properties
+		if !f.name(v.Name) || !f.service(p.SystemService) || !f.region(p.Region) {
+			continue
+		}
+		for _, cidr := range p.AddressPrefixes {
+			if err := c.AddCidr(cidr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * Loads an RIR delegated-stats file (registry|cc|type|start|value|date|status...).
+ * IPv4 records give an address count, IPv6 records a prefix length.
+ */
+func (c *CidrTable) LoadRirDelegated(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadRirDelegated)
+}
+
+func (c *CidrTable) ReadRirDelegated(r io.Reader, f *ImportFilter) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		/* Skips the version header, summary lines and asn records. */
+		if len(fields) < 7 || fields[1] == "*" || (fields[2] != "ipv4" && fields[2] != "ipv6") {
+			continue
+		}
+		if f != nil && (!f.match(fields[1], f.Country) || !f.match(fields[6], f.Status)) {
+			continue
+		}
+		start := net.ParseIP(fields[3])
+		value, err := strconv.ParseUint(fields[4], 10, 64)
+		if start == nil || err != nil || value == 0 {
+			return fmt.Errorf("line %d: bad record [%s]", lineNo, line)
+		}
+		if fields[2] == "ipv6" {
+			err = c.AddCidr(fmt.Sprintf("%s/%d", fields[3], value))
+		} else {
+			/* A count running off the end of the address space stops at 255.255.255.255. */
+			start = arith.Normalize(start)
+			end, _ := arith.Add(start, new(big.Int).SetUint64(value-1))
+			err = c.AddRange(start, end)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+ * Loads the permitted prefixes from Cisco ("ip prefix-list NAME [seq N] permit P [ge|le N]")
+ * or Juniper (hierarchical or "set policy-options prefix-list NAME P") configuration text.
+ * Other configuration lines are ignored; Name limits the load to one prefix list.
+ */
+func (c *CidrTable) LoadPrefixList(path string, f *ImportFilter) error {
+	return c.loadFile(path, f, (*CidrTable).ReadPrefixList)
+}
+
+func (c *CidrTable) ReadPrefixList(r io.Reader, f *ImportFilter) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	juniperList := "" /* Name of the Juniper prefix-list block we're in, if any. */
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexAny(line, "!#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var list, prefix string
+		switch {
+		case juniperList != "":
+			if fields[0] == "}" {
+				juniperList = ""
+				continue
+			}
+			list, prefix = juniperList, strings.TrimSuffix(fields[0], ";")
+		case (fields[0] == "ip" || fields[0] == "ipv6") && len(fields) >= 4 && fields[1] == "prefix-list":
+			list, fields = fields[2], fields[3:]
+			if len(fields) >= 2 && fields[0] == "seq" {
+				fields = fields[2:]
+			}
+			if len(fields) < 2 || fields[0] != "permit" {
+				continue
+			}
+			prefix = fields[1]
+		case fields[0] == "set" && len(fields) == 5 && fields[1] == "policy-options" && fields[2] == "prefix-list":
+			list, prefix = fields[3], fields[4]
+		case fields[0] == "prefix-list" && len(fields) == 3 && fields[2] == "{":
+			juniperList = fields[1]
+			continue
+		default:
+			continue
+		}
+
+		/* Skips Juniper's "apply-path" and friends. */
+		if !f.name(list) || strings.IndexAny(prefix, ".:") < 0 {
+			continue
+		}
+		if err := c.AddCidr(prefix); err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
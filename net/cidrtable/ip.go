@@ -0,0 +1,63 @@
+package cidrtable
+
+import (
+	"net"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/*
+ * Returns a CIDR block's network address and prefix length, with IPv4-mapped
+ * blocks (::ffff:a.b.c.d/ones) turned into IPv4 ones (a.b.c.d/ones-96).
+ * start is nil if the block isn't valid.
+ */
+func normalizeNet(n net.IPNet) (start net.IP, ones, bits int) {
+	ones, bits = n.Mask.Size()
+	masked := n.IP.Mask(n.Mask)
+	if bits == 0 || masked == nil {
+		return nil, 0, 0
+	}
+	start = arith.Normalize(masked)
+	if len(start) == net.IPv4len && bits == 8*net.IPv6len {
+		ones, bits = ones-96, 8*net.IPv4len
+	}
+	return start, ones, bits
+}
+
+/* Counts the zero bits at the end of ip. */
+func trailingZeros(ip net.IP) int {
+	n := 0
+	for i := len(ip) - 1; i >= 0; i-- {
+		if ip[i] == 0 {
+			n += 8
+			continue
+		}
+		for b := ip[i]; b&1 == 0; b >>= 1 {
+			n++
+		}
+		break
+	}
+	return n
+}
+
+/* Splits the range [start, end] into the smallest list of CIDR blocks covering it exactly. */
+func rangeToCidrs(start, end net.IP) []net.IPNet {
+	var cidrs []net.IPNet
+	bits := 8 * len(start)
+	for arith.Compare(start, end) <= 0 {
+		ones := bits - trailingZeros(start)
+		if ones < 0 {
+			ones = 0
+		}
+		for arith.Compare(arith.Last(start, ones), end) > 0 {
+			ones++
+		}
+		cidrs = append(cidrs, net.IPNet{IP: start, Mask: net.CIDRMask(ones, bits)})
+		next, ok := arith.Next(arith.Last(start, ones))
+		if !ok {
+			break
+		}
+		start = next
+	}
+	return cidrs
+}
@@ -0,0 +1,686 @@
+package cidrtable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/*
+ * MaxMind DB (.mmdb) support, per https://maxmind.github.io/MaxMind-DB/
+ *
+ * Range values are written as MMDB data. Supported Go types are string,
+ * []byte, bool, float32, float64, the signed and unsigned integers
+ * (narrowed to int32, uint16, uint32 or uint64), *big.Int (uint128), maps
+ * keyed by string and slices of any of these. Ranges without a value are
+ * written as an empty map. Values read back use the same types, with maps
+ * as map[string]interface{} and arrays as []interface{}.
+ */
+
+/* MMDB data section types. */
+const (
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+	mmdbDataSep  = 16 /* Zero bytes between the search tree and the data section. */
+	mmdbMetaTail = 128 * 1024
+)
+
+var mmdbMetaMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+/* Controls the metadata written by WriteMmdb. */
+type MmdbOptions struct {
+	DatabaseType string            /* Free-form name of the database's structure. */
+	Description  map[string]string /* Keyed by language code. */
+	Languages    []string          /* Locales present in the data. */
+	IpVersion    int               /* 4 or 6; 0 picks 4 for tables without IPv6. */
+	BuildEpoch   uint64            /* Build time in Unix seconds; 0 uses the current time. */
+}
+
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  int /* Offset into the data section plus one, 0 for no data. */
+	id    int
+}
+
+/* Sets data for the first ones bits of ip under n, which must not hold data itself. */
+func (n *mmdbNode) add(ip []byte, ones, data int) {
+	/* A tree's root can't hold data, so /0 becomes two /1s. */
+	if ones == 0 {
+		n.child = [2]*mmdbNode{{data: data}, {data: data}}
+		return
+	}
+	for bit := 0; bit < ones; bit++ {
+		b := (ip[bit/8] >> uint(7-bit%8)) & 1
+		if n.child[b] == nil {
+			n.child[b] = &mmdbNode{}
+		}
+		n = n.child[b]
+	}
+	n.data = data
+}
+
+/*
+ * Writes the table as a MaxMind DB. In IPv6 databases, IPv4 addresses live
+ * under ::/96: if the table has any, IPv6 ranges covering ::/96 are written
+ * around and between them, and IPv6 ranges inside it are an error.
+ */
+func (c *CidrTable) WriteMmdb(w io.Writer, o *MmdbOptions) error {
+	if o == nil {
+		o = &MmdbOptions{}
+	}
+	ranges := c.Ranges()
+	ipVersion := o.IpVersion
+	if ipVersion == 0 {
+		ipVersion = 4
+		if len(ranges) > 0 && len(ranges[len(ranges)-1].start) == net.IPv6len {
+			ipVersion = 6
+		}
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return fmt.Errorf("bad mmdb ip version %d", ipVersion)
+	}
+
+	/* Builds the search tree, sharing the data of equal values. */
+	var data bytes.Buffer
+	offsets := make(map[string]int)
+	root, ipv4 := &mmdbNode{}, &mmdbNode{}
+	if ipVersion == 4 {
+		ipv4 = root
+	}
+	for _, r := range ranges {
+		var enc bytes.Buffer
+		value := r.value
+		if value == nil {
+			value = map[string]interface{}{}
+		}
+		if err := mmdbEncode(&enc, value); err != nil {
+			return fmt.Errorf("%s: %s", r.String(), err)
+		}
+		offset, ok := offsets[enc.String()]
+		if !ok {
+			offset = data.Len()
+			offsets[enc.String()] = offset
+			data.Write(enc.Bytes())
+		}
+		for _, cidr := range r.Cidrs() {
+			ip := []byte(cidr.IP)
+			ones, _ := cidr.Mask.Size()
+			if len(ip) == net.IPv6len && ipVersion == 4 {
+				return fmt.Errorf("can't write %s to an IPv4 database", cidr.String())
+			} else if len(ip) == net.IPv4len {
+				ipv4.add(ip, ones, offset+1)
+			} else {
+				root.add(ip, ones, offset+1)
+			}
+		}
+	}
+	if ipVersion == 6 && len(ranges) > 0 && len(ranges[0].start) == net.IPv4len {
+		if err := graftIpv4(root, ipv4); err != nil {
+			return err
+		}
+	}
+
+	/* Numbers the internal nodes breadth-first; leaves only hold data. */
+	nodes := []*mmdbNode{root}
+	for i := 0; i < len(nodes); i++ {
+		nodes[i].id = i
+		for _, child := range nodes[i].child {
+			if child != nil && child.data == 0 {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	record := func(n *mmdbNode) uint64 {
+		switch {
+		case n == nil:
+			return uint64(nodeCount)
+		case n.data != 0:
+			return uint64(nodeCount + mmdbDataSep + n.data - 1)
+		default:
+			return uint64(n.id)
+		}
+	}
+
+	recordSize := 24
+	for max := uint64(nodeCount + mmdbDataSep + data.Len()); max >= 1<<uint(recordSize); {
+		if recordSize += 4; recordSize > 32 {
+			return fmt.Errorf("mmdb too large (%d nodes, %d bytes of data)", nodeCount, data.Len())
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, recordSize/4)
+	for _, n := range nodes {
+		left, right := record(n.child[0]), record(n.child[1])
+		switch recordSize {
+		case 24:
+			buf[0], buf[1], buf[2] = byte(left>>16), byte(left>>8), byte(left)
+			buf[3], buf[4], buf[5] = byte(right>>16), byte(right>>8), byte(right)
+		case 28:
+			buf[0], buf[1], buf[2] = byte(left>>16), byte(left>>8), byte(left)
+			buf[3] = byte((left>>24)<<4) | byte(right>>24&0x0f)
+			buf[4], buf[5], buf[6] = byte(right>>16), byte(right>>8), byte(right)
+		case 32:
+			binary.BigEndian.PutUint32(buf[0:], uint32(left))
+			binary.BigEndian.PutUint32(buf[4:], uint32(right))
+		}
+		bw.Write(buf)
+	}
+	bw.Write(make([]byte, mmdbDataSep))
+	bw.Write(data.Bytes())
+
+	epoch := o.BuildEpoch
+	if epoch == 0 {
+		epoch = uint64(time.Now().Unix())
+	}
+	description := o.Description
+	if description == nil {
+		description = map[string]string{}
+	}
+	languages := o.Languages
+	if languages == nil {
+		languages = []string{}
+	}
+	dbType := o.DatabaseType
+	if dbType == "" {
+		dbType = "CidrTable"
+	}
+	var meta bytes.Buffer
+	err := mmdbEncode(&meta, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 epoch,
+		"database_type":               dbType,
+		"description":                 description,
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return err
+	}
+	bw.Write(mmdbMetaMarker)
+	bw.Write(meta.Bytes())
+	return bw.Flush()
+}
+
+/*
+ * Puts the IPv4 tree at ::/96 in the IPv6 tree. An IPv6 range covering ::/96
+ * is pushed down a level at a time so it ends up all around it, and fills
+ * the gaps between the IPv4 ranges, which share their addresses with it.
+ */
+func graftIpv4(root, ipv4 *mmdbNode) error {
+	n, covered := root, false
+	for bit := 0; bit < 96; bit++ {
+		if n.data != 0 {
+			n.child = [2]*mmdbNode{{data: n.data}, {data: n.data}}
+			n.data, covered = 0, true
+		}
+		if n.child[0] == nil {
+			n.child[0] = &mmdbNode{}
+		}
+		n = n.child[0]
+	}
+	if (n.data != 0 && !covered) || n.child[0] != nil || n.child[1] != nil {
+		return fmt.Errorf("IPv6 ranges in ::/96 would shadow the IPv4 addresses there")
+	}
+	data := n.data
+	*n = *ipv4
+	if covered {
+		n.fill(data)
+	}
+	return nil
+}
+
+/* Gives every empty record under n the given data. */
+func (n *mmdbNode) fill(data int) {
+	for i, child := range n.child {
+		if child == nil {
+			n.child[i] = &mmdbNode{data: data}
+		} else if child.data == 0 {
+			child.fill(data)
+		}
+	}
+}
+
+/* Writes a control byte (and any extended type and size bytes) for a field. */
+func mmdbControl(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	if typ <= 7 {
+		ctrl = byte(typ << 5)
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		extra = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		extra = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	buf.WriteByte(ctrl)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(extra)
+}
+
+/* Writes an unsigned integer using as few bytes as possible. */
+func mmdbUint(buf *bytes.Buffer, typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	trimmed := bytes.TrimLeft(b[:], "\x00")
+	mmdbControl(buf, typ, len(trimmed))
+	buf.Write(trimmed)
+}
+
+func mmdbEncode(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case string:
+		mmdbControl(buf, mmdbString, len(val))
+		buf.WriteString(val)
+	case []byte:
+		mmdbControl(buf, mmdbBytes, len(val))
+		buf.Write(val)
+	case bool:
+		size := 0
+		if val {
+			size = 1
+		}
+		mmdbControl(buf, mmdbBool, size)
+	case float64:
+		mmdbControl(buf, mmdbDouble, 8)
+		binary.Write(buf, binary.BigEndian, val)
+	case float32:
+		mmdbControl(buf, mmdbFloat, 4)
+		binary.Write(buf, binary.BigEndian, val)
+	case uint16:
+		mmdbUint(buf, mmdbUint16, uint64(val))
+	case uint32:
+		mmdbUint(buf, mmdbUint32, uint64(val))
+	case uint64:
+		mmdbUint(buf, mmdbUint64, val)
+	case uint8:
+		mmdbUint(buf, mmdbUint16, uint64(val))
+	case uint:
+		mmdbUint(buf, mmdbUint64, uint64(val))
+	case int32:
+		mmdbControl(buf, mmdbInt32, 4)
+		binary.Write(buf, binary.BigEndian, val)
+	case int, int8, int16, int64:
+		n := reflect.ValueOf(val).Int()
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			if n < 0 {
+				return fmt.Errorf("integer %d doesn't fit an mmdb int32", n)
+			}
+			mmdbUint(buf, mmdbUint64, uint64(n))
+			break
+		}
+		return mmdbEncode(buf, int32(n))
+	case *big.Int:
+		if val.Sign() < 0 || val.BitLen() > 128 {
+			return fmt.Errorf("integer %s doesn't fit an mmdb uint128", val)
+		}
+		mmdbControl(buf, mmdbUint128, len(val.Bytes()))
+		buf.Write(val.Bytes())
+	default:
+		return mmdbEncodeReflect(buf, reflect.ValueOf(v))
+	}
+	return nil
+}
+
+/* Encodes maps keyed by string, and slices, of any element type. */
+func mmdbEncodeReflect(buf *bytes.Buffer, rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("mmdb map keys must be strings, not %s", rv.Type().Key())
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		mmdbControl(buf, mmdbMap, len(keys))
+		for _, k := range keys {
+			mmdbEncode(buf, k.String())
+			if err := mmdbEncode(buf, rv.MapIndex(k).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		mmdbControl(buf, mmdbArray, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := mmdbEncode(buf, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't write %T to an mmdb", rv.Interface())
+	}
+	return nil
+}
+
+/* Loads every network in a MaxMind DB into the table, with its data as the range value. */
+func (c *CidrTable) LoadMmdb(path string) error {
+	db, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = c.readMmdb(db); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+func (c *CidrTable) ReadMmdb(r io.Reader) error {
+	db, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.readMmdb(db)
+}
+
+type mmdbReader struct {
+	db         []byte
+	data       []byte /* The data section, which pointers are relative to. */
+	nodeCount  int
+	recordSize int
+	cache      map[int]interface{}
+	visited    map[int]bool
+	depth      int /* How deeply decode is nested, to stop on loops. */
+	table      *CidrTable
+}
+
+/* Deepest nesting of maps and arrays decode accepts. */
+const mmdbMaxDepth = 512
+
+func (c *CidrTable) readMmdb(db []byte) error {
+	m, ipVersion, err := openMmdb(db)
+	if err != nil {
+		return err
+	}
+	m.table = c
+	ip := make(net.IP, 4)
+	if ipVersion == 6 {
+		ip = make(net.IP, 16)
+	}
+	return m.walk(0, ip, 0)
+}
+
+/* Reads the metadata of db and returns a reader for its tree, along with its IP version. */
+func openMmdb(db []byte) (*mmdbReader, int, error) {
+	tail := db
+	if len(tail) > mmdbMetaTail {
+		tail = tail[len(tail)-mmdbMetaTail:]
+	}
+	i := bytes.LastIndex(tail, mmdbMetaMarker)
+	if i < 0 {
+		return nil, 0, fmt.Errorf("no mmdb metadata found")
+	}
+	metaStart := len(db) - len(tail) + i + len(mmdbMetaMarker)
+	meta := &mmdbReader{data: db[metaStart:]}
+	v, _, err := meta.decode(0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad mmdb metadata: %s", err)
+	}
+	metadata, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("bad mmdb metadata")
+	}
+
+	m := &mmdbReader{
+		db:         db,
+		nodeCount:  mmdbInt(metadata["node_count"]),
+		recordSize: mmdbInt(metadata["record_size"]),
+		cache:      make(map[int]interface{}),
+		visited:    make(map[int]bool),
+	}
+	ipVersion := mmdbInt(metadata["ip_version"])
+	if m.recordSize != 24 && m.recordSize != 28 && m.recordSize != 32 {
+		return nil, 0, fmt.Errorf("unsupported mmdb record size %d", m.recordSize)
+	} else if ipVersion != 4 && ipVersion != 6 {
+		return nil, 0, fmt.Errorf("unsupported mmdb ip version %d", ipVersion)
+	}
+	treeSize := m.nodeCount * m.recordSize / 4
+	if treeSize+mmdbDataSep > metaStart-len(mmdbMetaMarker) {
+		return nil, 0, fmt.Errorf("mmdb search tree overruns the file")
+	}
+	m.data = db[treeSize+mmdbDataSep : metaStart-len(mmdbMetaMarker)]
+	return m, ipVersion, nil
+}
+
+/* Returns the left and right records of node n. */
+func (m *mmdbReader) records(n int) (int, int) {
+	b := m.db[n*m.recordSize/4:]
+	switch m.recordSize {
+	case 24:
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2]), int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+	case 28:
+		left := int(b[3]>>4)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		right := int(b[3]&0x0f)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+		return left, right
+	default:
+		return int(binary.BigEndian.Uint32(b)), int(binary.BigEndian.Uint32(b[4:]))
+	}
+}
+
+/* Visits every network under node n, whose prefix is the first depth bits of ip. */
+func (m *mmdbReader) walk(n int, ip net.IP, depth int) error {
+	/* Skips aliases, like ::ffff:0:0/96 pointing back at the IPv4 subtree. */
+	if m.visited[n] {
+		return nil
+	}
+	m.visited[n] = true
+	left, right := m.records(n)
+	for bit, rec := range []int{left, right} {
+		child := make(net.IP, len(ip))
+		copy(child, ip)
+		if bit == 1 {
+			child[depth/8] |= 0x80 >> uint(depth%8)
+		}
+		switch {
+		case rec < m.nodeCount:
+			if depth+1 >= 8*len(ip) {
+				return fmt.Errorf("mmdb search tree is too deep")
+			}
+			if err := m.walk(rec, child, depth+1); err != nil {
+				return err
+			}
+		case rec == m.nodeCount:
+			/* No data. */
+		case rec < m.nodeCount+mmdbDataSep:
+			return fmt.Errorf("mmdb record %d points into the data section separator", rec)
+		default:
+			offset := rec - m.nodeCount - mmdbDataSep
+			value, ok := m.cache[offset]
+			if !ok {
+				var err error
+				if value, _, err = m.decode(offset); err != nil {
+					return err
+				}
+				m.cache[offset] = value
+			}
+			ones := depth + 1
+			/* IPv4 lives under ::/96 in IPv6 databases. */
+			if len(child) == net.IPv6len && ones >= 96 && bytes.Equal(child[:12], make([]byte, 12)) {
+				child, ones = child[12:], ones-96
+			}
+			m.table.insert(arith.Normalize(child), arith.Last(arith.Normalize(child), ones), value)
+		}
+	}
+	return nil
+}
+
+/* Decodes the field at offset in the data section, returning it and the offset after it. */
+func (m *mmdbReader) decode(offset int) (interface{}, int, error) {
+	if offset < 0 || offset >= len(m.data) {
+		return nil, 0, fmt.Errorf("mmdb data offset %d out of range", offset)
+	}
+	if m.depth >= mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("mmdb data nested too deeply at offset %d", offset)
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+	ctrl := m.data[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == mmdbPointer {
+		size := int(ctrl>>3) & 3
+		if offset+size+1 > len(m.data) {
+			return nil, 0, fmt.Errorf("truncated mmdb pointer")
+		}
+		b := m.data[offset:]
+		var ptr int
+		switch size {
+		case 0:
+			ptr = int(ctrl&7)<<8 | int(b[0])
+		case 1:
+			ptr = 2048 + (int(ctrl&7)<<16 | int(b[0])<<8 | int(b[1]))
+		case 2:
+			ptr = 526336 + (int(ctrl&7)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		case 3:
+			ptr = int(binary.BigEndian.Uint32(b))
+		}
+		/* Pointers to pointers aren't allowed, and would let a file loop forever. */
+		if ptr < len(m.data) && int(m.data[ptr]>>5) == mmdbPointer {
+			return nil, 0, fmt.Errorf("mmdb pointer at %d points to another pointer", offset-1)
+		}
+		v, _, err := m.decode(ptr)
+		return v, offset + size + 1, err
+	}
+	if typ == 0 {
+		if offset >= len(m.data) {
+			return nil, 0, fmt.Errorf("truncated mmdb field")
+		}
+		typ = 7 + int(m.data[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(m.data) {
+			return nil, 0, fmt.Errorf("truncated mmdb field")
+		}
+		s := 0
+		for _, b := range m.data[offset : offset+n] {
+			s = s<<8 | int(b)
+		}
+		size = []int{29, 285, 65821}[n-1] + s
+		offset += n
+	}
+
+	switch typ {
+	case mmdbMap:
+		val := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, next, err := m.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("mmdb map key is %T, not a string", k)
+			}
+			if val[key], offset, err = m.decode(next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return val, offset, nil
+	case mmdbArray:
+		val := make([]interface{}, size)
+		for i := range val {
+			var err error
+			if val[i], offset, err = m.decode(offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return val, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(m.data) {
+		return nil, 0, fmt.Errorf("truncated mmdb field")
+	}
+	b := m.data[offset : offset+size]
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("bad mmdb double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("bad mmdb float size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("bad mmdb integer size %d", size)
+		}
+		var n uint64
+		for _, x := range b {
+			n = n<<8 | uint64(x)
+		}
+		switch typ {
+		case mmdbUint16:
+			return uint16(n), offset, nil
+		case mmdbUint32:
+			return uint32(n), offset, nil
+		case mmdbInt32:
+			return int32(uint32(n)), offset, nil
+		}
+		return n, offset, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported mmdb data type %d", typ)
+}
+
+/* Reads an unsigned metadata field as an int, 0 if missing. */
+func mmdbInt(v interface{}) int {
+	switch n := v.(type) {
+	case uint16:
+		return int(n)
+	case uint32:
+		return int(n)
+	case uint64:
+		return int(n)
+	}
+	return 0
+}
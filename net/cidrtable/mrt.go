@@ -0,0 +1,276 @@
+package cidrtable
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* MRT record types and TABLE_DUMP_V2 subtypes (RFC 6396, RFC 8050). */
+const (
+	mrtTableDumpV2 = 13
+
+	mrtPeerIndexTable        = 1
+	mrtRibIpv4Unicast        = 2
+	mrtRibIpv6Unicast        = 4
+	mrtRibIpv4UnicastAddPath = 8
+	mrtRibIpv6UnicastAddPath = 10
+
+	bgpAttrAsPath       = 2
+	bgpAttrFlagExtended = 0x10
+	bgpAsSet            = 1
+)
+
+/* The value stored for each prefix loaded from an MRT RIB dump. */
+type BgpRoute struct {
+	Origin uint32 /* Origin ASN, 0 if the path ends in a multi-member AS_SET. */
+	AsPath string /* Space-separated AS path, with sets as {a,b}. */
+}
+
+func (b BgpRoute) String() string {
+	return fmt.Sprintf("AS%d [%s]", b.Origin, b.AsPath)
+}
+
+type mrtPrefix struct {
+	ip    net.IP
+	ones  int
+	route BgpRoute
+}
+
+/*
+ * Loads a TABLE_DUMP_V2 RIB dump (as published by RouteViews and RIPE RIS), optionally
+ * gzip or bzip2 compressed, mapping each prefix to a BgpRoute.
+ */
+func (c *CidrTable) LoadMrt(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err = c.ReadMrt(fh); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+/*
+ * Reads a RIB dump into the table. When several peers carry a prefix, the shortest
+ * AS path wins. Prefixes are added least specific first, so lookups find the
+ * longest match.
+ */
+func (c *CidrTable) ReadMrt(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	case bytes.Equal(magic, []byte("BZh")):
+		br = bufio.NewReader(bzip2.NewReader(br))
+	}
+
+	var prefixes []mrtPrefix
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		mrtType := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.ReadFull(br, body); err != nil {
+			return err
+		}
+		if mrtType != mrtTableDumpV2 {
+			continue
+		}
+
+		var p mrtPrefix
+		var err error
+		switch subtype {
+		case mrtRibIpv4Unicast:
+			p, err = parseRibEntry(body, net.IPv4len, false)
+		case mrtRibIpv6Unicast:
+			p, err = parseRibEntry(body, net.IPv6len, false)
+		case mrtRibIpv4UnicastAddPath:
+			p, err = parseRibEntry(body, net.IPv4len, true)
+		case mrtRibIpv6UnicastAddPath:
+			p, err = parseRibEntry(body, net.IPv6len, true)
+		default:
+			/* The peer index table, multicast and generic RIBs aren't needed. */
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, p)
+	}
+
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		if cmp := arith.Compare(prefixes[i].ip, prefixes[j].ip); cmp != 0 {
+			return cmp < 0
+		}
+		return prefixes[i].ones < prefixes[j].ones
+	})
+	c.addNested(prefixes)
+	return nil
+}
+
+/*
+ * Adds prefixes sorted by address, less specific first, giving each address
+ * the route of the longest prefix covering it. Prefixes still open wait on a
+ * stack, and each stretch of address space is added once, in order, so the
+ * table is built in one pass.
+ */
+func (c *CidrTable) addNested(prefixes []mrtPrefix) {
+	type open struct {
+		last  net.IP
+		route BgpRoute
+	}
+	var stack []open
+	var next net.IP /* First address not added yet, nil past the end of the address space. */
+	flush := func(o open) {
+		if next != nil && arith.Compare(next, o.last) <= 0 {
+			c.insert(next, o.last, o.route)
+		}
+		if after, ok := arith.Next(o.last); ok {
+			next = after
+		} else {
+			next = nil
+		}
+	}
+
+	for _, p := range prefixes {
+		for len(stack) > 0 && arith.Compare(stack[len(stack)-1].last, p.ip) < 0 {
+			flush(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 && next != nil && arith.Compare(next, p.ip) < 0 {
+			before, _ := arith.Prev(p.ip)
+			c.insert(next, before, stack[len(stack)-1].route)
+		}
+		next = p.ip
+		stack = append(stack, open{arith.Last(p.ip, p.ones), p.route})
+	}
+	for len(stack) > 0 {
+		flush(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+	}
+}
+
+/* Parses a RIB_IPV4_UNICAST/RIB_IPV6_UNICAST record body (RFC 6396 4.3.2). */
+func parseRibEntry(body []byte, ipLen int, addPath bool) (mrtPrefix, error) {
+	var p mrtPrefix
+	if len(body) < 5 {
+		return p, fmt.Errorf("short RIB record")
+	}
+	p.ones = int(body[4])
+	n := (p.ones + 7) / 8
+	if p.ones > 8*ipLen || len(body) < 7+n {
+		return p, fmt.Errorf("bad prefix length %d in RIB record", p.ones)
+	}
+	p.ip = make(net.IP, ipLen)
+	copy(p.ip, body[5:5+n])
+	p.ip = p.ip.Mask(net.CIDRMask(p.ones, 8*ipLen))
+
+	count := int(binary.BigEndian.Uint16(body[5+n:]))
+	entries := body[7+n:]
+	best := -1
+	for i := 0; i < count; i++ {
+		/* peer index (2), originated time (4), [path id (4)], attribute length (2) */
+		skip := 6
+		if addPath {
+			skip += 4
+		}
+		if len(entries) < skip+2 {
+			return p, fmt.Errorf("short RIB entry for %s/%d", p.ip, p.ones)
+		}
+		attrLen := int(binary.BigEndian.Uint16(entries[skip:]))
+		if len(entries) < skip+2+attrLen {
+			return p, fmt.Errorf("short attributes for %s/%d", p.ip, p.ones)
+		}
+		route, hops, err := parseAsPath(entries[skip+2 : skip+2+attrLen])
+		if err != nil {
+			return p, fmt.Errorf("%s/%d: %s", p.ip, p.ones, err)
+		}
+		if best < 0 || hops < best {
+			p.route, best = route, hops
+		}
+		entries = entries[skip+2+attrLen:]
+	}
+	return p, nil
+}
+
+/* Finds the AS_PATH among the BGP path attributes. ASNs are always 4 bytes in TABLE_DUMP_V2. */
+func parseAsPath(attrs []byte) (BgpRoute, int, error) {
+	var route BgpRoute
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return route, 0, fmt.Errorf("short path attribute")
+		}
+		flags, code := attrs[0], attrs[1]
+		length, hdr := int(attrs[2]), 3
+		if flags&bgpAttrFlagExtended != 0 {
+			if len(attrs) < 4 {
+				return route, 0, fmt.Errorf("short path attribute")
+			}
+			length, hdr = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		}
+		if len(attrs) < hdr+length {
+			return route, 0, fmt.Errorf("truncated path attribute %d", code)
+		}
+		if code == bgpAttrAsPath {
+			return parseAsSegments(attrs[hdr : hdr+length])
+		}
+		attrs = attrs[hdr+length:]
+	}
+	return route, 0, nil
+}
+
+func parseAsSegments(data []byte) (BgpRoute, int, error) {
+	var route BgpRoute
+	var path []string
+	hops := 0
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+4*int(data[1]) {
+			return route, 0, fmt.Errorf("truncated AS_PATH segment")
+		}
+		segType, count := data[0], int(data[1])
+		asns := make([]string, count)
+		for i := range asns {
+			route.Origin = binary.BigEndian.Uint32(data[2+4*i:])
+			asns[i] = strconv.FormatUint(uint64(route.Origin), 10)
+		}
+		if segType == bgpAsSet {
+			/* A set counts as one hop, and only names the origin if it has one member. */
+			path = append(path, "{"+strings.Join(asns, ",")+"}")
+			if count > 1 {
+				route.Origin = 0
+			}
+			hops++
+		} else {
+			path = append(path, asns...)
+			hops += count
+		}
+		data = data[2+4*count:]
+	}
+	route.AsPath = strings.Join(path, " ")
+	return route, hops, nil
+}
@@ -0,0 +1,92 @@
+package cidrtable
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/*
+ * Picks addresses uniformly at random from a set of ranges. Every address is
+ * equally likely, so a mix of IPv4 and IPv6 space gives almost nothing but
+ * IPv6. The same seed always gives the same addresses.
+ */
+type RandomIps struct {
+	ranges  []IpRange
+	offsets []*big.Int /* Index of each range's first address in the whole set. */
+	total   *big.Int
+	rng     *rand.Rand
+}
+
+/* Picks from the addresses in the table that aren't in exclude, which may be nil. */
+func (c *CidrTable) Random(exclude *CidrTable, seed int64) (*RandomIps, error) {
+	return newRandomIps(c.Ranges(), exclude, seed)
+}
+
+/* Picks from the addresses in the range that aren't in exclude, which may be nil. */
+func (r IpRange) Random(exclude *CidrTable, seed int64) (*RandomIps, error) {
+	return newRandomIps([]IpRange{r}, exclude, seed)
+}
+
+func newRandomIps(ranges []IpRange, exclude *CidrTable, seed int64) (*RandomIps, error) {
+	if exclude != nil {
+		ranges = subtractRanges(ranges, exclude.Ranges())
+	}
+	g := &RandomIps{ranges: ranges, total: new(big.Int), rng: rand.New(rand.NewSource(seed))}
+	for _, r := range ranges {
+		g.offsets = append(g.offsets, new(big.Int).Set(g.total))
+		g.total.Add(g.total, r.Size())
+	}
+	if g.total.Sign() == 0 {
+		return nil, errors.New("no addresses to pick from")
+	}
+	return g, nil
+}
+
+/* Returns a random address from the set. */
+func (g *RandomIps) Next() net.IP {
+	n := new(big.Int).Rand(g.rng, g.total)
+	i := sort.Search(len(g.offsets), func(i int) bool {
+		return g.offsets[i].Cmp(n) > 0
+	}) - 1
+	ip, _ := arith.Add(g.ranges[i].start, n.Sub(n, g.offsets[i]))
+	return ip
+}
+
+/* Number of addresses Next picks from. */
+func (g *RandomIps) Size() *big.Int {
+	return new(big.Int).Set(g.total)
+}
+
+/* Returns what's left of the sorted ranges in a once the sorted ranges in b are taken out. */
+func subtractRanges(a, b []IpRange) []IpRange {
+	var out []IpRange
+	j := 0
+	for _, r := range a {
+		for j < len(b) && arith.Compare(b[j].end, r.start) < 0 {
+			j++
+		}
+		start, done := r.start, false
+		/* An exclusion can reach into the next range too, so j stays put. */
+		for k := j; k < len(b) && arith.Compare(b[k].start, r.end) <= 0; k++ {
+			if arith.Compare(b[k].start, start) > 0 {
+				before, _ := arith.Prev(b[k].start)
+				out = append(out, newIpRange(start, before, r.value))
+			}
+			after, ok := arith.Next(b[k].end)
+			if !ok || arith.Compare(b[k].end, r.end) >= 0 {
+				done = true
+				break
+			}
+			start = after
+		}
+		if !done {
+			out = append(out, newIpRange(start, r.end, r.value))
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package cidrtable
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* Makes a standalone IpRange covering start through end, inclusive. */
+func NewRange(start, end net.IP) (IpRange, error) {
+	s, e, err := checkRange(start, end)
+	if err != nil {
+		return IpRange{}, err
+	}
+	return newIpRange(s, e, nil), nil
+}
+
+/*
+ * Parses a range written as "start-end" (spaces allowed around the dash),
+ * "a.b.c.d-e" (IPv4 with only the last octet of the end), a CIDR block or a
+ * single IP.
+ */
+func ParseRange(s string) (IpRange, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		left, right := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		start, end := net.ParseIP(left), net.ParseIP(right)
+		if start != nil && end == nil && start.To4() != nil && !strings.ContainsRune(left, ':') {
+			/* "192.0.2.10-20" */
+			end = net.ParseIP(left[:strings.LastIndexByte(left, '.')+1] + right)
+		}
+		if start == nil || end == nil {
+			return IpRange{}, fmt.Errorf("couldn't parse range [%s]", s)
+		}
+		return NewRange(start, end)
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return NewRange(ip, ip)
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return IpRange{}, fmt.Errorf("couldn't parse range [%s]", s)
+	}
+	start, ones, _ := normalizeNet(*ipnet)
+	return newIpRange(start, arith.Last(start, ones), nil), nil
+}
+
+/* Returns the smallest list of CIDR blocks that exactly covers start through end. */
+func RangeToCidrs(start, end net.IP) ([]net.IPNet, error) {
+	r, err := NewRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return r.Cidrs(), nil
+}
+
+/* Returns the number of addresses in the range. */
+func (r IpRange) Size() *big.Int {
+	d, _ := arith.Distance(r.start, r.end)
+	return d.Add(d, big.NewInt(1))
+}
+
+/* Normalizes a range's ends, making sure they're the same family and in order. */
+func checkRange(start, end net.IP) (net.IP, net.IP, error) {
+	s, e := arith.Normalize(start), arith.Normalize(end)
+	if s == nil || e == nil {
+		return nil, nil, fmt.Errorf("invalid range [%s-%s]", start, end)
+	} else if len(s) != len(e) {
+		return nil, nil, fmt.Errorf("mixed address families in range [%s-%s]", start, end)
+	} else if arith.Compare(s, e) > 0 {
+		return nil, nil, fmt.Errorf("range start is after end [%s-%s]", start, end)
+	}
+	return s, e, nil
+}
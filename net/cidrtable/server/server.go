@@ -0,0 +1,318 @@
+package server
+
+/*
+ * An HTTP/JSON lookup service over one or more CidrTables:
+ *
+ *   GET  /lookup?ip=<ip>[&table=<name>]   one address
+ *   POST /lookup {"ips": [...]}           a batch of addresses
+ *   GET  /stats                           per-table sizes and load times
+ *   POST /reload                          reload every source file
+ *
+ * Reloads build new tables off to the side and swap them in, so lookups
+ * keep being answered (from the old tables) while files are re-read.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yargevad/net/cidrtable"
+)
+
+/* Max addresses in one batch lookup, and max size of its request body. */
+const (
+	MaxBatch     = 10000
+	maxBatchBody = 1 << 20
+)
+
+/* A file to load into a named table. */
+type Source struct {
+	Name   string /* Table name used in requests and responses. */
+	Format string /* One of: cidr aws gcp azure rir prefix-list mrt mmdb */
+	Path   string
+}
+
+/* Parses a source given as name=format:path, or just path for a cidr list named after the file. */
+func ParseSource(s string) (Source, error) {
+	src := Source{Format: "cidr", Path: s}
+	if i := strings.IndexRune(s, '='); i > 0 {
+		src.Name, src.Path = s[:i], s[i+1:]
+		if j := strings.IndexRune(src.Path, ':'); j > 0 {
+			src.Format, src.Path = src.Path[:j], src.Path[j+1:]
+		}
+	} else {
+		src.Name = s[strings.LastIndex(s, "/")+1:]
+	}
+	if src.Name == "" || src.Path == "" {
+		return src, fmt.Errorf("bad source [%s], want name=format:path", s)
+	}
+	return src, nil
+}
+
+/* Reads a source into a new table. */
+func (src Source) Load() (*cidrtable.CidrTable, error) {
+	c, _ := cidrtable.InitCidr()
+	var err error
+	switch src.Format {
+	case "cidr", "":
+		err = c.LoadCidrList(src.Path, nil)
+	case "aws":
+		err = c.LoadAwsIpRanges(src.Path, nil)
+	case "gcp":
+		err = c.LoadGcpCloud(src.Path, nil)
+	case "azure":
+		err = c.LoadAzureServiceTags(src.Path, nil)
+	case "rir":
+		err = c.LoadRirDelegated(src.Path, nil)
+	case "prefix-list":
+		err = c.LoadPrefixList(src.Path, nil)
+	case "mrt":
+		err = c.LoadMrt(src.Path)
+	case "mmdb":
+		err = c.LoadMmdb(src.Path)
+	default:
+		err = fmt.Errorf("unknown format [%s] for table [%s]", src.Format, src.Name)
+	}
+	return c, err
+}
+
+type table struct {
+	Source
+	cidrs    *cidrtable.CidrTable
+	ranges   int
+	loaded   time.Time
+	loadTime time.Duration
+}
+
+type Server struct {
+	sources []Source
+	mux     *http.ServeMux
+
+	mu      sync.RWMutex /* Guards tables, which reloads replace wholesale. */
+	tables  []*table
+	reload  sync.Mutex /* Serializes reloads. */
+	lookups uint64
+	reloads uint64
+}
+
+/* Loads every source and returns a Server ready to handle requests. */
+func New(sources []Source) (*Server, error) {
+	seen := make(map[string]bool)
+	for _, src := range sources {
+		if seen[src.Name] {
+			return nil, fmt.Errorf("duplicate table name [%s]", src.Name)
+		}
+		seen[src.Name] = true
+	}
+
+	s := &Server{sources: sources, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/lookup", s.handleLookup)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/reload", s.handleReload)
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+ * Re-reads every source. If any of them fails, the current tables are kept
+ * and the error is returned.
+ */
+func (s *Server) Reload() error {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	tables := make([]*table, len(s.sources))
+	for i, src := range s.sources {
+		start := time.Now()
+		c, err := src.Load()
+		if err != nil {
+			return err
+		}
+		tables[i] = &table{
+			Source:   src,
+			cidrs:    c,
+			ranges:   c.Len(),
+			loaded:   time.Now(),
+			loadTime: time.Since(start),
+		}
+	}
+
+	s.mu.Lock()
+	s.tables = tables
+	s.mu.Unlock()
+	atomic.AddUint64(&s.reloads, 1)
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type Match struct {
+	Table string      `json:"table"`
+	Range string      `json:"range"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type Result struct {
+	Ip      string  `json:"ip"`
+	Matches []Match `json:"matches"`
+	Error   string  `json:"error,omitempty"`
+}
+
+/* Looks ip up in the named table, or in every table if name is empty. */
+func (s *Server) Lookup(ip, name string) Result {
+	atomic.AddUint64(&s.lookups, 1)
+	res := Result{Ip: ip, Matches: []Match{}}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		res.Error = fmt.Sprintf("couldn't parse ip [%s]", ip)
+		return res
+	}
+
+	s.mu.RLock()
+	tables := s.tables
+	s.mu.RUnlock()
+	for _, t := range tables {
+		if name != "" && t.Name != name {
+			continue
+		}
+		if r, ok := t.cidrs.Lookup(parsed); ok {
+			res.Matches = append(res.Matches, Match{Table: t.Name, Range: r.String(), Value: r.Value()})
+		}
+	}
+	return res
+}
+
+func (s *Server) hasTable(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tables {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("table")
+	if name != "" && !s.hasTable(name) {
+		writeError(w, http.StatusNotFound, "no table [%s]", name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			writeError(w, http.StatusBadRequest, "specify ip")
+			return
+		}
+		res := s.Lookup(ip, name)
+		if res.Error != "" {
+			writeJSON(w, http.StatusBadRequest, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	case http.MethodPost:
+		var req struct {
+			Ips []string `json:"ips"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: %s", err)
+			return
+		} else if len(req.Ips) > MaxBatch {
+			writeError(w, http.StatusRequestEntityTooLarge, "too many ips (%d > %d)", len(req.Ips), MaxBatch)
+			return
+		}
+		results := make([]Result, len(req.Ips))
+		for i, ip := range req.Ips {
+			results[i] = s.Lookup(ip, name)
+		}
+		writeJSON(w, http.StatusOK, map[string][]Result{"results": results})
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+type TableStats struct {
+	Name     string    `json:"name"`
+	Format   string    `json:"format"`
+	Path     string    `json:"path"`
+	Ranges   int       `json:"ranges"`
+	Loaded   time.Time `json:"loaded"`
+	LoadTime string    `json:"load_time"`
+}
+
+type Stats struct {
+	Tables  []TableStats `json:"tables"`
+	Lookups uint64       `json:"lookups"`
+	Reloads uint64       `json:"reloads"`
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.RLock()
+	tables := s.tables
+	s.mu.RUnlock()
+
+	stats := Stats{
+		Tables:  make([]TableStats, len(tables)),
+		Lookups: atomic.LoadUint64(&s.lookups),
+		Reloads: atomic.LoadUint64(&s.reloads),
+	}
+	for i, t := range tables {
+		stats.Tables[i] = TableStats{
+			Name:     t.Name,
+			Format:   t.Format,
+			Path:     t.Path,
+			Ranges:   t.ranges,
+			Loaded:   t.loaded,
+			LoadTime: t.loadTime.String(),
+		}
+	}
+	return stats
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Stats())
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, "reload failed: %s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Stats())
+}
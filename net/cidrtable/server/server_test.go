@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *Server, string) {
+	dir, err := ioutil.TempDir("", "cidrserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "corp"), "10.0.0.0/8 corp\n192.168.0.0/16 lab # comment\n")
+	writeFile(t, filepath.Join(dir, "cloud.json"), `{"prefixes": [{"ip_prefix": "52.94.76.0/22"}]}`)
+
+	var sources []Source
+	for _, s := range []string{"corp=cidr:" + filepath.Join(dir, "corp"), "aws=aws:" + filepath.Join(dir, "cloud.json")} {
+		src, err := ParseSource(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, src)
+	}
+	s, err := New(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(s), s, dir
+}
+
+func getJSON(t *testing.T, resp *http.Response, err error, status int, v interface{}) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("%s => %d (%d): %s\n", resp.Request.URL, resp.StatusCode, status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	ts, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	cases := []struct {
+		query  string
+		status int
+		want   string /* table=range=value, space separated */
+	}{
+		{"ip=10.1.2.3", http.StatusOK, "corp=10.0.0.0/8=corp"},
+		{"ip=192.168.1.1", http.StatusOK, "corp=192.168.0.0/16=lab"},
+		{"ip=52.94.77.1", http.StatusOK, "aws=52.94.76.0/22=<nil>"},
+		{"ip=52.94.77.1&table=corp", http.StatusOK, ""},
+		{"ip=8.8.8.8", http.StatusOK, ""},
+		{"ip=bogus", http.StatusBadRequest, ""},
+		{"ip=10.0.0.1&table=nope", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		var res Result
+		resp, err := http.Get(ts.URL + "/lookup?" + tc.query)
+		getJSON(t, resp, err, tc.status, &res)
+		var got []string
+		for _, m := range res.Matches {
+			got = append(got, m.Table+"="+m.Range+"="+valueString(m.Value))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s => [%s] (%s)\n", tc.query, strings.Join(got, " "), tc.want)
+		}
+	}
+}
+
+func valueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return "<nil>"
+}
+
+func TestBatchLookup(t *testing.T) {
+	ts, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	var res struct {
+		Results []Result `json:"results"`
+	}
+	resp, err := http.Post(ts.URL+"/lookup", "application/json",
+		strings.NewReader(`{"ips": ["10.0.0.1", "8.8.8.8", "nope", "52.94.76.1"]}`))
+	getJSON(t, resp, err, http.StatusOK, &res)
+	if len(res.Results) != 4 {
+		t.Fatalf("got %d results (4)\n", len(res.Results))
+	}
+	if len(res.Results[0].Matches) != 1 || len(res.Results[1].Matches) != 0 ||
+		res.Results[2].Error == "" || res.Results[3].Matches[0].Table != "aws" {
+		t.Errorf("unexpected results %+v\n", res.Results)
+	}
+}
+
+func TestReload(t *testing.T) {
+	ts, s, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	/* Lookups running across reloads should always see one table or the other. */
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if res := s.Lookup("10.0.0.1", "corp"); len(res.Matches) != 1 {
+					t.Errorf("lookup during reload => %+v\n", res)
+					return
+				}
+			}
+		}()
+	}
+
+	writeFile(t, filepath.Join(dir, "corp"), "10.0.0.0/8 corp\n172.16.0.0/12 vpn\n")
+	for i := 0; i < 5; i++ {
+		if err := s.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stats Stats
+	resp, err := http.Post(ts.URL+"/reload", "", nil)
+	getJSON(t, resp, err, http.StatusOK, &stats)
+	close(stop)
+	wg.Wait()
+
+	if stats.Reloads != 7 || stats.Tables[0].Ranges != 2 {
+		t.Errorf("unexpected stats %+v\n", stats)
+	}
+	if res := s.Lookup("172.16.5.5", ""); len(res.Matches) != 1 || res.Matches[0].Value != "vpn" {
+		t.Errorf("172.16.5.5 after reload => %+v\n", res)
+	}
+
+	/* A broken source keeps the old tables. */
+	writeFile(t, filepath.Join(dir, "cloud.json"), "{")
+	resp, err = http.Post(ts.URL+"/reload", "", nil)
+	var failed map[string]string
+	getJSON(t, resp, err, http.StatusInternalServerError, &failed)
+	if res := s.Lookup("52.94.76.1", "aws"); len(res.Matches) != 1 {
+		t.Errorf("52.94.76.1 after failed reload => %+v\n", res)
+	}
+}
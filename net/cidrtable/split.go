@@ -0,0 +1,84 @@
+package cidrtable
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* The most subnets Split will return. */
+const (
+	maxSplitBits = 20
+	MaxSplit     = 1 << maxSplitBits
+)
+
+/* Splits a CIDR block into its subnets of prefix length ones, in order. */
+func Split(prefix net.IPNet, ones int) ([]net.IPNet, error) {
+	start, have, bits := normalizeNet(prefix)
+	if start == nil {
+		return nil, fmt.Errorf("bad prefix [%s]", prefix.String())
+	}
+	/* A mapped block's subnet length is given in IPv6 terms too. */
+	if _, given := prefix.Mask.Size(); given != bits {
+		ones -= given - bits
+	}
+	if ones < have || ones > bits {
+		return nil, fmt.Errorf("can't split [%s] into /%d blocks", prefix.String(), ones)
+	} else if ones-have > maxSplitBits {
+		return nil, fmt.Errorf("splitting [%s] into /%d blocks makes more than %d", prefix.String(), ones, MaxSplit)
+	}
+
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	subnets := make([]net.IPNet, 0, 1<<uint(ones-have))
+	for i := 0; i < 1<<uint(ones-have); i++ {
+		subnets = append(subnets, net.IPNet{IP: start, Mask: net.CIDRMask(ones, bits)})
+		start, _ = arith.Add(start, step)
+	}
+	return subnets, nil
+}
+
+/*
+ * Splits a CIDR block into at least n equal subnets: the smallest power of
+ * two that's n or more, so asking for 6 gives 8.
+ */
+func SplitN(prefix net.IPNet, n int) ([]net.IPNet, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("can't split [%s] into %d blocks", prefix.String(), n)
+	}
+	have, _ := prefix.Mask.Size()
+	k := 0
+	for 1<<uint(k) < n {
+		k++
+	}
+	return Split(prefix, have+k)
+}
+
+/* Returns the smallest CIDR block covering every range. They must all be the same family. */
+func Supernet(ranges []IpRange) (net.IPNet, error) {
+	if len(ranges) == 0 {
+		return net.IPNet{}, fmt.Errorf("no ranges to cover")
+	}
+	lo, hi := ranges[0].start, ranges[0].end
+	for _, r := range ranges[1:] {
+		if len(r.start) != len(lo) {
+			return net.IPNet{}, fmt.Errorf("mixed address families [%s] and [%s]", ranges[0], r)
+		}
+		if arith.Compare(r.start, lo) < 0 {
+			lo = r.start
+		}
+		if arith.Compare(r.end, hi) > 0 {
+			hi = r.end
+		}
+	}
+
+	/* The block is the bits lo and hi have in common. */
+	bits := 8 * len(lo)
+	ones := 0
+	for ones < bits && lo[ones/8]&(0x80>>uint(ones%8)) == hi[ones/8]&(0x80>>uint(ones%8)) {
+		ones++
+	}
+	mask := net.CIDRMask(ones, bits)
+	return net.IPNet{IP: lo.Mask(mask), Mask: mask}, nil
+}
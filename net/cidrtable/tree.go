@@ -0,0 +1,103 @@
+package cidrtable
+
+import (
+	"net"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/*
+ * The nodes of a CidrTable also form a treap keyed by start address, so
+ * insert can find its place in O(log n) whatever order ranges arrive in.
+ * The linked list stays the authority on order; the tree is only an index
+ * into it.
+ */
+
+/* The last node starting at or before ip, nil if there isn't one. */
+func (c *CidrTable) floor(ip net.IP) *IpRangeNode {
+	var found *IpRangeNode
+	for t := c.root; t != nil; {
+		if arith.Compare(t.data.start, ip) <= 0 {
+			found, t = t, t.right
+		} else {
+			t = t.left
+		}
+	}
+	return found
+}
+
+func (c *CidrTable) treeAdd(n *IpRangeNode) {
+	/* xorshift32, a zero-value table starts it anywhere but zero. */
+	if c.seed == 0 {
+		c.seed = 2463534242
+	}
+	c.seed ^= c.seed << 13
+	c.seed ^= c.seed >> 17
+	c.seed ^= c.seed << 5
+	n.left, n.right, n.prio = nil, nil, c.seed
+	c.root = treeInsert(c.root, n)
+}
+
+func treeInsert(t, n *IpRangeNode) *IpRangeNode {
+	if t == nil {
+		return n
+	}
+	if n.prio > t.prio {
+		n.left, n.right = treeSplit(t, n.data.start)
+		return n
+	}
+	if arith.Compare(n.data.start, t.data.start) < 0 {
+		t.left = treeInsert(t.left, n)
+	} else {
+		t.right = treeInsert(t.right, n)
+	}
+	return t
+}
+
+/* Splits t into the nodes starting before ip and the rest. */
+func treeSplit(t *IpRangeNode, ip net.IP) (*IpRangeNode, *IpRangeNode) {
+	if t == nil {
+		return nil, nil
+	}
+	if arith.Compare(t.data.start, ip) < 0 {
+		l, r := treeSplit(t.right, ip)
+		t.right = l
+		return t, r
+	}
+	l, r := treeSplit(t.left, ip)
+	t.left = r
+	return l, t
+}
+
+func treeRemove(t, n *IpRangeNode) *IpRangeNode {
+	if t == nil {
+		return nil
+	}
+	if t == n {
+		m := treeMerge(n.left, n.right)
+		n.left, n.right = nil, nil
+		return m
+	}
+	if arith.Compare(n.data.start, t.data.start) < 0 {
+		t.left = treeRemove(t.left, n)
+	} else {
+		t.right = treeRemove(t.right, n)
+	}
+	return t
+}
+
+/* Joins two treaps, every node in a starting before every node in b. */
+func treeMerge(a, b *IpRangeNode) *IpRangeNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.prio > b.prio {
+		a.right = treeMerge(a.right, b)
+		return a
+	}
+	b.left = treeMerge(a, b.left)
+	return b
+}
@@ -0,0 +1,187 @@
+package cidrtable
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yargevad/net/ip/arith"
+)
+
+/* A named subnet that needs room for Hosts addresses. */
+type Requirement struct {
+	Name  string
+	Hosts uint64
+}
+
+/* One requirement's place in a Plan. */
+type Allocation struct {
+	Requirement
+	Prefix net.IPNet
+	Usable *big.Int /* Host addresses in Prefix: all of them for IPv6, less network and broadcast for IPv4. */
+}
+
+/* A VLSM allocation of requirements within a parent block. */
+type Plan struct {
+	Parent      net.IPNet
+	Allocations []Allocation /* In address order. */
+	Free        []net.IPNet  /* What's left of Parent. */
+}
+
+/*
+ * Allocates each requirement the smallest block that fits it (IPv4 blocks
+ * lose their network and broadcast addresses), largest first so every block
+ * lands aligned right after the one before and the leftover space stays in
+ * one piece at the end. Equal sizes keep their input order, so the same
+ * requirements always give the same plan.
+ */
+func PlanVLSM(parent net.IPNet, reqs []Requirement) (*Plan, error) {
+	start, ones, bits := normalizeNet(parent)
+	if start == nil {
+		return nil, fmt.Errorf("bad parent prefix [%s]", parent.String())
+	}
+	p := &Plan{Parent: net.IPNet{IP: start, Mask: net.CIDRMask(ones, bits)}}
+	end := arith.Last(p.Parent.IP, ones)
+
+	for _, req := range reqs {
+		if req.Hosts == 0 {
+			return nil, fmt.Errorf("requirement [%s] needs no hosts", req.Name)
+		}
+		size := new(big.Int).SetUint64(req.Hosts)
+		if bits == 32 {
+			size.Add(size, big.NewInt(2))
+		}
+		/* Hosts <= 2^hostBits - 1 is the same as size - 1 having at most hostBits bits. */
+		hostBits := new(big.Int).Sub(size, big.NewInt(1)).BitLen()
+		if hostBits > bits-ones {
+			return nil, fmt.Errorf("requirement [%s] for %d hosts doesn't fit in [%s]", req.Name, req.Hosts, p.Parent.String())
+		}
+		p.Allocations = append(p.Allocations, Allocation{Requirement: req, Prefix: net.IPNet{Mask: net.CIDRMask(bits-hostBits, bits)}})
+	}
+	sort.SliceStable(p.Allocations, func(i, j int) bool {
+		oi, _ := p.Allocations[i].Prefix.Mask.Size()
+		oj, _ := p.Allocations[j].Prefix.Mask.Size()
+		return oi < oj
+	})
+
+	next, more := p.Parent.IP, true
+	for i := range p.Allocations {
+		a := &p.Allocations[i]
+		aOnes, _ := a.Prefix.Mask.Size()
+		if !more || arith.Compare(arith.Last(next, aOnes), end) > 0 {
+			return nil, fmt.Errorf("requirements don't all fit in [%s]; [%s] is the first left over", p.Parent.String(), a.Name)
+		}
+		a.Prefix.IP = next
+		a.Usable = new(big.Int).Lsh(big.NewInt(1), uint(bits-aOnes))
+		if bits == 32 && aOnes < 31 {
+			a.Usable.Sub(a.Usable, big.NewInt(2))
+		}
+		next, more = arith.Next(arith.Last(next, aOnes))
+	}
+	if more && arith.Compare(next, end) <= 0 {
+		p.Free = rangeToCidrs(next, end)
+	}
+	return p, nil
+}
+
+/* Returns the plan as a table, each allocation's range carrying its name. */
+func (p *Plan) Table() *CidrTable {
+	c, _ := InitCidr()
+	for _, a := range p.Allocations {
+		c.AddCidrValue(a.Prefix.String(), a.Name)
+	}
+	return c
+}
+
+/* Lays the plan out as a table of allocations and free blocks, with totals. */
+func (p *Plan) String() string {
+	var buf bytes.Buffer
+	ones, bits := p.Parent.Mask.Size()
+	total := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	used := new(big.Int)
+
+	fmt.Fprintf(&buf, "Parent: %s (%s addresses)\n\n", p.Parent.String(), total)
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPREFIX\tNEEDED\tUSABLE\tFIRST\tLAST")
+	for _, a := range p.Allocations {
+		aOnes, _ := a.Prefix.Mask.Size()
+		first, last := a.Prefix.IP, arith.Last(a.Prefix.IP, aOnes)
+		if bits == 32 && aOnes < 31 {
+			first, _ = arith.Next(first)
+			last, _ = arith.Prev(last)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", a.Name, a.Prefix.String(), a.Hosts, a.Usable, first, last)
+		used.Add(used, new(big.Int).Lsh(big.NewInt(1), uint(bits-aOnes)))
+	}
+	for _, f := range p.Free {
+		fmt.Fprintf(tw, "(free)\t%s\n", f.String())
+	}
+	tw.Flush()
+
+	pct := new(big.Rat).SetFrac(new(big.Int).Mul(used, big.NewInt(100)), total)
+	fmt.Fprintf(&buf, "\nAllocated %s of %s addresses (%s%%).\n", used, total, pct.FloatString(1))
+	return buf.String()
+}
+
+/* Loads requirements from a file; see ReadRequirements. */
+func LoadRequirements(path string) ([]Requirement, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	reqs, err := ReadRequirements(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return reqs, nil
+}
+
+var requirementRe = regexp.MustCompile(`^([^:\s]+)\s*:?\s*(\d+)(\s+hosts?)?$`)
+
+/*
+ * Reads requirements written "name: 500 hosts" (the colon and "hosts" are
+ * optional), one per line or several to a line separated by commas. Blank
+ * lines and "#" comments are skipped; names must be unique.
+ */
+func ReadRequirements(r io.Reader) ([]Requirement, error) {
+	var reqs []Requirement
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexRune(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			m := requirementRe.FindStringSubmatch(item)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: bad requirement [%s]", lineNo, item)
+			}
+			hosts, err := strconv.ParseUint(m[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			} else if seen[m[1]] {
+				return nil, fmt.Errorf("line %d: duplicate requirement [%s]", lineNo, m[1])
+			}
+			seen[m[1]] = true
+			reqs = append(reqs, Requirement{Name: m[1], Hosts: hosts})
+		}
+	}
+	return reqs, scanner.Err()
+}
@@ -0,0 +1,121 @@
+package arith
+
+/*
+ * Address arithmetic for net.IP: ordering, next/previous address, adding
+ * big offsets, distances and prefix ends, with overflow at either end of the
+ * address space reported rather than wrapped silently.
+ *
+ * The family comes from the length: 4 bytes is IPv4 and 16 is IPv6, and
+ * arithmetic stays within it, so the address after 4-byte 255.255.255.255
+ * is an overflow, not ::1:0:0. net.ParseIP returns IPv4 in 16 bytes, so pass
+ * addresses through Normalize first. Nothing here normalizes for you, since
+ * IPv6 arithmetic can legitimately land in ::ffff:0:0/96.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+/* Returns the 4-byte form of IPv4 addresses and the 16-byte form of everything else. */
+func Normalize(ip net.IP) net.IP {
+	if p4 := ip.To4(); p4 != nil {
+		return p4
+	}
+	return ip.To16()
+}
+
+/* Orders IPv4 before IPv6, then by address. */
+func Compare(a, b net.IP) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare([]byte(a), []byte(b))
+}
+
+/* Returns the IP just after ip, and false (with the wrapped-around address) at the end of the address space. */
+func Next(ip net.IP) (net.IP, bool) {
+	n := clone(ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return n, true
+		}
+	}
+	return n, false
+}
+
+/* Returns the IP just before ip, and false (with the wrapped-around address) at the start of the address space. */
+func Prev(ip net.IP) (net.IP, bool) {
+	p := clone(ip)
+	for i := len(p) - 1; i >= 0; i-- {
+		p[i]--
+		if p[i] != 0xff {
+			return p, true
+		}
+	}
+	return p, false
+}
+
+/*
+ * Returns ip plus n (which may be negative). If that falls outside the
+ * address space, returns false with the result clamped to the first or last
+ * address.
+ */
+func Add(ip net.IP, n *big.Int) (net.IP, bool) {
+	sum := new(big.Int).SetBytes(ip)
+	sum.Add(sum, n)
+	out := make(net.IP, len(ip))
+	switch {
+	case sum.Sign() < 0:
+		return out, false
+	case sum.BitLen() > 8*len(ip):
+		for i := range out {
+			out[i] = 0xff
+		}
+		return out, false
+	}
+	b := sum.Bytes()
+	copy(out[len(out)-len(b):], b)
+	return out, true
+}
+
+/* Returns ip minus n, clamped and false like Add on overflow. */
+func Sub(ip net.IP, n *big.Int) (net.IP, bool) {
+	return Add(ip, new(big.Int).Neg(n))
+}
+
+/* Returns b - a, which is negative if b comes first. Both must be the same family. */
+func Distance(a, b net.IP) (*big.Int, error) {
+	if len(a) == 0 || len(a) != len(b) {
+		return nil, fmt.Errorf("can't measure from [%s] to [%s]", a, b)
+	}
+	return new(big.Int).Sub(new(big.Int).SetBytes(b), new(big.Int).SetBytes(a)), nil
+}
+
+/* Returns the last IP in the block of the given prefix length containing ip. */
+func Last(ip net.IP, ones int) net.IP {
+	l := clone(ip)
+	for i := range l {
+		switch {
+		case ones >= 8*(i+1):
+			continue
+		case ones <= 8*i:
+			l[i] = 0xff
+		default:
+			l[i] |= 0xff >> uint(ones-8*i)
+		}
+	}
+	return l
+}
+
+func clone(ip net.IP) net.IP {
+	c := make(net.IP, len(ip))
+	copy(c, ip)
+	return c
+}
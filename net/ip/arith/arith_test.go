@@ -0,0 +1,114 @@
+package arith
+
+import (
+	"math/big"
+	"net"
+	"testing"
+)
+
+func parse(s string) net.IP {
+	return Normalize(net.ParseIP(s))
+}
+
+func TestNextPrev(t *testing.T) {
+	cases := []struct {
+		in         string
+		next, prev string
+		nextOk     bool
+		prevOk     bool
+	}{
+		{"10.0.0.255", "10.0.1.0", "10.0.0.254", true, true},
+		{"10.0.1.0", "10.0.1.1", "10.0.0.255", true, true},
+		{"255.255.255.255", "0.0.0.0", "255.255.255.254", false, true},
+		{"0.0.0.0", "0.0.0.1", "255.255.255.255", true, false},
+		{"::ffff:10.0.0.1", "10.0.0.2", "10.0.0.0", true, true},
+		{"2001:db8::ffff", "2001:db8::1:0", "2001:db8::fffe", true, true},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", false, true},
+		{"::", "::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", true, false},
+	}
+	for _, c := range cases {
+		ip := parse(c.in)
+		if got, ok := Next(ip); got.String() != c.next || ok != c.nextOk {
+			t.Errorf("Next(%s) = [%s] %v (%s %v)\n", c.in, got, ok, c.next, c.nextOk)
+		}
+		if got, ok := Prev(ip); got.String() != c.prev || ok != c.prevOk {
+			t.Errorf("Prev(%s) = [%s] %v (%s %v)\n", c.in, got, ok, c.prev, c.prevOk)
+		}
+		if !ip.Equal(parse(c.in)) {
+			t.Errorf("Next/Prev changed their argument [%s]\n", c.in)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	big2 := func(s string) *big.Int {
+		n, _ := new(big.Int).SetString(s, 0)
+		return n
+	}
+	cases := []struct {
+		in   string
+		n    string
+		want string
+		ok   bool
+	}{
+		{"10.0.0.1", "255", "10.0.1.0", true},
+		{"10.0.0.1", "-2", "9.255.255.255", true},
+		{"255.255.255.0", "255", "255.255.255.255", true},
+		{"255.255.255.0", "256", "255.255.255.255", false},
+		{"0.0.0.5", "-6", "0.0.0.0", false},
+		{"2001:db8::", "0x10000000000000000", "2001:db8:0:1::", true},
+		{"::1", "340282366920938463463374607431768211455", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", false},
+	}
+	for _, c := range cases {
+		got, ok := Add(parse(c.in), big2(c.n))
+		if got.String() != c.want || ok != c.ok {
+			t.Errorf("Add(%s, %s) = [%s] %v (%s %v)\n", c.in, c.n, got, ok, c.want, c.ok)
+		}
+	}
+	if got, ok := Sub(parse("10.0.1.0"), big.NewInt(1)); !ok || got.String() != "10.0.0.255" {
+		t.Errorf("Sub(10.0.1.0, 1) = [%s] %v\n", got, ok)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want string
+	}{
+		{"10.0.0.0", "10.0.1.0", "256"},
+		{"10.0.1.0", "10.0.0.0", "-256"},
+		{"0.0.0.0", "255.255.255.255", "4294967295"},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "340282366920938463463374607431768211455"},
+	}
+	for _, c := range cases {
+		got, err := Distance(parse(c.a), parse(c.b))
+		if err != nil || got.String() != c.want {
+			t.Errorf("Distance(%s, %s) = %s, %v (%s)\n", c.a, c.b, got, err, c.want)
+		}
+	}
+	if _, err := Distance(parse("10.0.0.1"), parse("::1")); err == nil {
+		t.Errorf("Distance across families, want error\n")
+	}
+}
+
+func TestCompareLast(t *testing.T) {
+	if Compare(parse("255.255.255.255"), parse("::")) >= 0 {
+		t.Errorf("IPv4 should sort before IPv6\n")
+	}
+	if Compare(parse("::ffff:10.0.0.1"), parse("10.0.0.1")) != 0 {
+		t.Errorf("IPv4-mapped should equal IPv4 once normalized\n")
+	}
+	/* Unnormalized, the 16-byte form is IPv6 space. */
+	if got, ok := Next(net.ParseIP("255.255.255.255")); !ok || got.String() != "::1:0:0:0" {
+		t.Errorf("Next(16-byte 255.255.255.255) = [%s] %v\n", got, ok)
+	}
+	if got := Last(parse("::"), 80); len(got) != 16 || Compare(got, parse("::")) <= 0 {
+		t.Errorf("Last(::, 80) = [%s], want the 16-byte ::ffff:ffff:ffff\n", got)
+	}
+	if got := Last(parse("10.1.2.3"), 20); got.String() != "10.1.15.255" {
+		t.Errorf("Last(10.1.2.3, 20) = [%s]\n", got)
+	}
+	if got := Last(parse("2001:db8::"), 33); got.String() != "2001:db8:7fff:ffff:ffff:ffff:ffff:ffff" {
+		t.Errorf("Last(2001:db8::, 33) = [%s]\n", got)
+	}
+}
@@ -0,0 +1,173 @@
+package convert
+
+import (
+	"net"
+	"sort"
+)
+
+/*
+ * An entry from the IANA IPv4/IPv6 Special-Purpose Address Registries (plus
+ * the top-level multicast blocks, which the special-purpose registries leave
+ * to their own). The booleans are the registry's columns; "N/A" is false.
+ */
+type Special struct {
+	Prefix      *net.IPNet
+	Kind        string /* Short tag for the block, e.g. "private", "cgnat", "documentation". */
+	Name        string /* The registry's name for the block. */
+	Rfc         string
+	Allocated   string /* Year and month, as in the registry. */
+	Source      bool   /* Valid as a source address. */
+	Destination bool   /* Valid as a destination address. */
+	Forwardable bool   /* Routers may forward it. */
+	Global      bool   /* Globally reachable. */
+	Reserved    bool   /* Reserved by protocol. */
+}
+
+/* flags holds the Source, Destination, Forwardable, Global and Reserved columns as T or F. */
+func special(cidr, kind, name, rfc, allocated, flags string) Special {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	if p4 := ipnet.IP.To4(); p4 != nil {
+		ipnet.IP = p4
+	}
+	s := Special{Prefix: ipnet, Kind: kind, Name: name, Rfc: rfc, Allocated: allocated}
+	for i, col := range []*bool{&s.Source, &s.Destination, &s.Forwardable, &s.Global, &s.Reserved} {
+		*col = i < len(flags) && flags[i] == 'T'
+	}
+	return s
+}
+
+/* Registry entries in address order; deprecated blocks have no flags. */
+var specials = []Special{
+	special("0.0.0.0/8", "this-network", "\"This network\"", "RFC791", "1981-09", "TFFFT"),
+	special("0.0.0.0/32", "this-network", "\"This host on this network\"", "RFC1122", "1981-09", "TFFFT"),
+	special("10.0.0.0/8", "private", "Private-Use", "RFC1918", "1996-02", "TTTFF"),
+	special("100.64.0.0/10", "cgnat", "Shared Address Space", "RFC6598", "2012-04", "TTTFF"),
+	special("127.0.0.0/8", "loopback", "Loopback", "RFC1122", "1981-09", "FFFFT"),
+	special("169.254.0.0/16", "link-local", "Link Local", "RFC3927", "2005-05", "TTFFT"),
+	special("172.16.0.0/12", "private", "Private-Use", "RFC1918", "1996-02", "TTTFF"),
+	special("192.0.0.0/24", "protocol", "IETF Protocol Assignments", "RFC6890", "2010-01", "FFFFF"),
+	special("192.0.0.0/29", "protocol", "IPv4 Service Continuity Prefix", "RFC7335", "2011-06", "TTTFF"),
+	special("192.0.0.8/32", "protocol", "IPv4 dummy address", "RFC7600", "2015-03", "TFFFF"),
+	special("192.0.0.9/32", "anycast", "Port Control Protocol Anycast", "RFC7723", "2015-10", "TTTTF"),
+	special("192.0.0.10/32", "anycast", "Traversal Using Relays around NAT Anycast", "RFC8155", "2017-02", "TTTTF"),
+	special("192.0.0.170/32", "nat64", "NAT64/DNS64 Discovery", "RFC8880", "2013-02", "FFFFT"),
+	special("192.0.0.171/32", "nat64", "NAT64/DNS64 Discovery", "RFC8880", "2013-02", "FFFFT"),
+	special("192.0.2.0/24", "documentation", "Documentation (TEST-NET-1)", "RFC5737", "2010-01", "FFFFF"),
+	special("192.31.196.0/24", "as112", "AS112-v4", "RFC7535", "2014-12", "TTTTF"),
+	special("192.52.193.0/24", "amt", "AMT", "RFC7450", "2014-12", "TTTTF"),
+	special("192.88.99.0/24", "6to4", "Deprecated (6to4 Relay Anycast)", "RFC7526", "2001-06", ""),
+	special("192.168.0.0/16", "private", "Private-Use", "RFC1918", "1996-02", "TTTFF"),
+	special("192.175.48.0/24", "as112", "Direct Delegation AS112 Service", "RFC7534", "1996-01", "TTTTF"),
+	special("198.18.0.0/15", "benchmarking", "Benchmarking", "RFC2544", "1999-03", "TTTFF"),
+	special("198.51.100.0/24", "documentation", "Documentation (TEST-NET-2)", "RFC5737", "2010-01", "FFFFF"),
+	special("203.0.113.0/24", "documentation", "Documentation (TEST-NET-3)", "RFC5737", "2010-01", "FFFFF"),
+	special("224.0.0.0/4", "multicast", "Multicast", "RFC5771", "1989-08", "FTTTF"),
+	special("224.0.0.0/24", "multicast", "Local Network Control Block", "RFC5771", "1989-08", "FTFFT"),
+	special("224.0.1.0/24", "multicast", "Internetwork Control Block", "RFC5771", "1989-08", "FTTTF"),
+	special("232.0.0.0/8", "multicast", "Source-Specific Multicast Block", "RFC4607", "2006-08", "FTTTF"),
+	special("233.0.0.0/8", "multicast", "GLOP Block", "RFC3180", "2001-09", "FTTTF"),
+	special("239.0.0.0/8", "multicast", "Administratively Scoped Block", "RFC2365", "1998-07", "FTTFF"),
+	special("240.0.0.0/4", "reserved", "Reserved", "RFC1112", "1989-08", "FFFFT"),
+	special("255.255.255.255/32", "broadcast", "Limited Broadcast", "RFC8190", "1984-10", "FTFFT"),
+
+	special("::/128", "unspecified", "Unspecified Address", "RFC4291", "2006-02", "TFFFT"),
+	special("::1/128", "loopback", "Loopback Address", "RFC4291", "2006-02", "FFFFT"),
+	special("64:ff9b::/96", "nat64", "IPv4-IPv6 Translat.", "RFC6052", "2010-10", "TTTTF"),
+	special("64:ff9b:1::/48", "nat64", "IPv4-IPv6 Translat.", "RFC8215", "2017-06", "TTTFF"),
+	special("100::/64", "discard", "Discard-Only Address Block", "RFC6666", "2012-06", "TTTFF"),
+	special("2001::/23", "protocol", "IETF Protocol Assignments", "RFC2928", "2000-09", "FFFFF"),
+	special("2001::/32", "teredo", "TEREDO", "RFC4380", "2006-01", "TTTFF"),
+	special("2001:1::1/128", "anycast", "Port Control Protocol Anycast", "RFC7723", "2015-10", "TTTTF"),
+	special("2001:1::2/128", "anycast", "Traversal Using Relays around NAT Anycast", "RFC8155", "2017-02", "TTTTF"),
+	special("2001:2::/48", "benchmarking", "Benchmarking", "RFC5180", "2008-04", "TTTFF"),
+	special("2001:3::/32", "amt", "AMT", "RFC7450", "2014-12", "TTTTF"),
+	special("2001:4:112::/48", "as112", "AS112-v6", "RFC7535", "2014-12", "TTTTF"),
+	special("2001:10::/28", "orchid", "Deprecated (previously ORCHID)", "RFC4843", "2007-03", ""),
+	special("2001:20::/28", "orchid", "ORCHIDv2", "RFC7343", "2014-07", "TTTTF"),
+	special("2001:30::/28", "drone", "Drone Remote ID Protocol Entity Tags (DETs) Prefix", "RFC9374", "2022-12", "TTTTF"),
+	special("2001:db8::/32", "documentation", "Documentation", "RFC3849", "2004-07", "FFFFF"),
+	special("2002::/16", "6to4", "6to4", "RFC3056", "2001-02", "TTTFF"),
+	special("2620:4f:8000::/48", "as112", "Direct Delegation AS112 Service", "RFC7534", "2011-05", "TTTTF"),
+	special("3fff::/20", "documentation", "Documentation", "RFC9637", "2024-07", "FFFFF"),
+	special("5f00::/16", "srv6", "Segment Routing (SRv6) SIDs", "RFC9602", "2024-04", "TTTFF"),
+	special("fc00::/7", "ula", "Unique-Local", "RFC4193", "2005-10", "TTTFF"),
+	special("fe80::/10", "link-local", "Link-Local Unicast", "RFC4291", "2006-02", "TTFFT"),
+	special("ff00::/8", "multicast", "Multicast", "RFC4291", "2006-02", "FTTFF"),
+}
+
+/*
+ * Returns every registry entry containing ip, most specific first, or nil
+ * for ordinary unicast space. IPv4-mapped addresses are classified as IPv4.
+ */
+func Classify(ip net.IP) []Special {
+	p := ip.To4()
+	if p == nil {
+		p = ip.To16()
+	}
+	if p == nil {
+		return nil
+	}
+	var found []Special
+	for _, s := range specials {
+		if len(s.Prefix.IP) == len(p) && s.Prefix.Contains(p) {
+			found = append(found, s)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		oi, _ := found[i].Prefix.Mask.Size()
+		oj, _ := found[j].Prefix.Mask.Size()
+		return oi > oj
+	})
+	return found
+}
+
+/* The IPv6 multicast scopes from RFC 7346, by the scope nibble. */
+var multicastScopes = map[byte]string{
+	0x1: "interface-local",
+	0x2: "link-local",
+	0x3: "realm-local",
+	0x4: "admin-local",
+	0x5: "site-local",
+	0x8: "organization-local",
+	0xe: "global",
+}
+
+/*
+ * Returns the scope of a multicast address ("" if ip isn't multicast): from
+ * the scope nibble for IPv6, and for IPv4 link-local for 224.0.0.0/24 and
+ * the RFC 2365 blocks within 239.0.0.0/8.
+ */
+func MulticastScope(ip net.IP) string {
+	if p4 := ip.To4(); p4 != nil {
+		switch {
+		case p4[0] < 224 || p4[0] > 239:
+			return ""
+		case p4[0] == 224 && p4[1] == 0 && p4[2] == 0:
+			return "link-local"
+		case p4[0] == 239 && p4[1] == 255:
+			return "site-local"
+		case p4[0] == 239 && p4[1]&0xfc == 192:
+			return "organization-local"
+		case p4[0] == 239:
+			return "admin-local"
+		}
+		return "global"
+	}
+	p := ip.To16()
+	if p == nil || p[0] != 0xff {
+		return ""
+	}
+	if scope, ok := multicastScopes[p[1]&0xf]; ok {
+		return scope
+	}
+	return "reserved"
+}
+
+/* True if ip falls in none of the special-purpose blocks, or only in globally reachable ones. */
+func IsGlobal(ip net.IP) bool {
+	found := Classify(ip)
+	return len(found) == 0 || found[0].Global
+}
@@ -3,20 +3,64 @@ package convert
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"net"
 	"strings"
 )
 
-const (
-	Binary = iota
-	Hex
-)
+/* Controls the layout of ConvertIPOptions output. */
+type Options struct {
+	Pad         bool   /* Zero-pad each group to its full width. */
+	Separator   string /* Between groups, "" for "." (IPv4) or ":" (IPv6). */
+	NoSeparator bool   /* Run the groups together instead. */
+	Upper       bool   /* Upper case hex digits. */
+	Prefix      bool   /* Mark each group with 0b (binary), 0x (hex) or 0 (octal). */
+	GroupBits   int    /* Bits per group, 0 for 8 (IPv4) or 16 (IPv6). */
+
+	/* For hex output only; these take over from the layout fields above. */
+	Compress  bool /* RFC 5952 form: lower case, no leading zeros, longest zero run as "::", IPv4 as ::ffff:a.b.c.d. */
+	EmbedIPv4 bool /* Last 32 bits as dotted decimal, with IPv4 shown as ::ffff:a.b.c.d. */
+}
+
+/* The layout ConvertIP has always used: binary and hex padded, octal with a leading 0. */
+func DefaultOptions(convertTo Format) *Options {
+	return &Options{
+		Pad:    convertTo == Binary || convertTo == Hex,
+		Prefix: convertTo == Octal,
+	}
+}
+
+/* Returns an IPv4 address as a 32-bit unsigned integer. */
+func ToUint32(ip net.IP) (uint32, error) {
+	p4 := ip.To4()
+	if p4 == nil {
+		return 0, fmt.Errorf("not an IPv4 address [%s]", ip)
+	}
+	return uint32(p4[0])<<24 | uint32(p4[1])<<16 | uint32(p4[2])<<8 | uint32(p4[3]), nil
+}
+
+/* Returns an address as an unsigned integer: 32 bits for IPv4, 128 for IPv6. */
+func ToBigInt(ip net.IP) *big.Int {
+	if p4 := ip.To4(); p4 != nil {
+		return new(big.Int).SetBytes(p4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+/* Converts an IP (or the IP part of a CIDR block) to the given format. */
+func ConvertIP(inIp string, convertTo Format) (string, error) {
+	return ConvertIPOptions(inIp, convertTo, nil)
+}
+
+/* Converts inIp like ConvertIP, laid out according to o (nil for ConvertIP's layout). */
+func ConvertIPOptions(inIp string, convertTo Format, o *Options) (string, error) {
+	fn, err := convertTo.formatter()
+	if err != nil {
+		return "", err
+	}
 
-func ConvertIP(inIp string, convertTo int) (string, error) {
-	var buf bytes.Buffer
 	var ip net.IP
 	//var ipnet *net.IPNet
-	var err error
 
 	/* Get index of forward slash (indicating subnet), < 0 if not there. */
 	fwdSlashIdx := strings.IndexRune(inIp, '/')
@@ -37,38 +81,142 @@ func ConvertIP(inIp string, convertTo int) (string, error) {
 		return "", fmt.Errorf("zero-length IP parsed from [%s]", inIp)
 	}
 
-	/* TODO: optional zero-padding in format strings */
 	p := ip
-
 	if p4 := p.To4(); len(p4) == net.IPv4len {
-		for i := 0; i < 4; i++ {
-			if i > 0 {
-				buf.WriteString(".")
-			}
-			if convertTo == Binary {
-				buf.WriteString(fmt.Sprintf("%08b", uint(p4[i])))
-			} else if convertTo == Hex {
-				buf.WriteString(fmt.Sprintf("%02x", uint(p4[i])))
-			}
+		p = p4
+	} else if len(p) != net.IPv6len {
+		return "", fmt.Errorf("WARNING: unexpected ip length %d for [%s]\n", len(p), inIp)
+	}
+
+	if o == nil {
+		o = DefaultOptions(convertTo)
+	}
+	return fn(p, o)
+}
+
+/* Returns ip in RFC 5952 canonical form, with IPv4 and IPv4-mapped addresses as ::ffff:a.b.c.d. */
+func Canonical(ip net.IP) string {
+	if ip.To4() != nil {
+		return formatIPv6(ip.To16(), true, true)
+	}
+	return formatIPv6(ip.To16(), true, false)
+}
+
+/* Returns ip as eight fully padded groups of four hex digits. */
+func Expanded(ip net.IP) string {
+	return formatIPv6(ip.To16(), false, false)
+}
+
+/* Writes a 16-byte ip as hex groups, optionally compressed and with a dotted IPv4 tail. */
+func formatIPv6(p net.IP, compress, embed bool) string {
+	count := 8
+	if embed {
+		count = 6
+	}
+	groups := make([]string, count)
+	for i := range groups {
+		g := uint(p[2*i])<<8 | uint(p[2*i+1])
+		if compress {
+			groups[i] = fmt.Sprintf("%x", g)
+		} else {
+			groups[i] = fmt.Sprintf("%04x", g)
+		}
+	}
+	tail := ""
+	if embed {
+		tail = fmt.Sprintf("%d.%d.%d.%d", p[12], p[13], p[14], p[15])
+	}
+	if !compress {
+		if embed {
+			groups = append(groups, tail)
 		}
-		return buf.String(), nil
+		return strings.Join(groups, ":")
 	}
 
-	if len(p) != net.IPv6len {
-		return "", fmt.Errorf("WARNING: unexpected ip length %d for [%s]\n", len(p), inIp)
+	/* Finds the first longest run of two or more zero groups. */
+	runStart, runLen := -1, 1
+	for i := 0; i < count; {
+		j := i
+		for j < count && groups[j] == "0" {
+			j++
+		}
+		if j-i > runLen {
+			runStart, runLen = i, j-i
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+	if runStart < 0 {
+		if embed {
+			groups = append(groups, tail)
+		}
+		return strings.Join(groups, ":")
+	}
+	right := groups[runStart+runLen:]
+	if embed {
+		right = append(right, tail)
 	}
+	return strings.Join(groups[:runStart], ":") + "::" + strings.Join(right, ":")
+}
+
+/* Writes p as groups of o.GroupBits bits, each in the given base. */
+func formatGroups(p net.IP, base int, o *Options) (string, error) {
+	var buf bytes.Buffer
+	bits := 8 * len(p)
 
-	/* TODO: optionally collapse zeroes */
-	for i := 0; i < net.IPv6len; i += 2 {
-		if i > 0 {
-			buf.WriteString(fmt.Sprintf(":"))
+	groupBits := o.GroupBits
+	if groupBits == 0 {
+		groupBits = 8
+		if len(p) == net.IPv6len {
+			groupBits = 16
 		}
-		if convertTo == Binary {
-			buf.WriteString(fmt.Sprintf("%016b", (uint32(p[i])<<8)|uint32(p[i+1])))
-		} else if convertTo == Hex {
-			buf.WriteString(fmt.Sprintf("%04x", (uint32(p[i])<<8)|uint32(p[i+1])))
+	}
+	if groupBits < 0 || groupBits > bits || bits%groupBits != 0 {
+		return "", fmt.Errorf("group size must divide %d bits, not %d", bits, groupBits)
+	}
+
+	sep := o.Separator
+	if o.NoSeparator {
+		sep = ""
+	} else if sep == "" {
+		sep = "."
+		if len(p) == net.IPv6len {
+			sep = ":"
 		}
 	}
-	return buf.String(), nil
 
+	n := ToBigInt(p)
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(groupBits)), big.NewInt(1))
+	/* Padding goes out to the width of the largest group value. */
+	width := len(mask.Text(base))
+	for shift := bits - groupBits; shift >= 0; shift -= groupBits {
+		if shift < bits-groupBits {
+			buf.WriteString(sep)
+		}
+		group := new(big.Int).And(new(big.Int).Rsh(n, uint(shift)), mask)
+		digits := group.Text(base)
+		if o.Pad && len(digits) < width {
+			digits = strings.Repeat("0", width-len(digits)) + digits
+		}
+		if o.Upper {
+			digits = strings.ToUpper(digits)
+		}
+		if o.Prefix {
+			switch base {
+			case 2:
+				digits = "0b" + digits
+			case 16:
+				digits = "0x" + digits
+			case 8:
+				/* Like %#o, only add a 0 if there isn't one already. */
+				if digits[0] != '0' {
+					digits = "0" + digits
+				}
+			}
+		}
+		buf.WriteString(digits)
+	}
+	return buf.String(), nil
 }
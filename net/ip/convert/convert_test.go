@@ -0,0 +1,465 @@
+package convert
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestConvertIP(t *testing.T) {
+	cases := []struct {
+		in        string
+		convertTo Format
+		want      string
+	}{
+		{"10.0.0.1", Binary, "00001010.00000000.00000000.00000001"},
+		{"10.0.0.1", Hex, "0a.00.00.01"},
+		{"10.0.0.1", Decimal, "10.0.0.1"},
+		{"10.0.0.1", Integer, "167772161"},
+		{"255.255.255.255", Integer, "4294967295"},
+		{"10.0.0.1", Octal, "012.0.0.01"},
+		{"192.168.1.0/24", Integer, "3232235776"},
+		{"2001:db8::1", Hex, "2001:0db8:0000:0000:0000:0000:0000:0001"},
+		{"2001:db8::1", Decimal, "8193:3512:0:0:0:0:0:1"},
+		{"2001:db8::1", Octal, "020001:06670:0:0:0:0:0:01"},
+		{"2001:db8::1", Integer, "42540766411282592856903984951653826561"},
+		{"::", Integer, "0"},
+	}
+	for _, c := range cases {
+		got, err := ConvertIP(c.in, c.convertTo)
+		if err != nil {
+			t.Errorf("[%s] (%s): %s\n", c.in, c.convertTo, err)
+		} else if got != c.want {
+			t.Errorf("[%s] (%s) = [%s] (%s)\n", c.in, c.convertTo, got, c.want)
+		}
+	}
+}
+
+func TestParseIP(t *testing.T) {
+	cases := []struct {
+		in   string
+		from Format
+		want string
+	}{
+		{"10.0.0.1", Auto, "10.0.0.1"},
+		{"2001:db8::1", Auto, "2001:db8::1"},
+		{"0a000001", Auto, "10.0.0.1"},
+		{"0x0A000001", Auto, "10.0.0.1"},
+		{"0a.00.00.01", Auto, "10.0.0.1"},
+		{"00001010.00000000.00000000.00000001", Auto, "10.0.0.1"},
+		{"00001010000000000000000000000001", Auto, "10.0.0.1"},
+		{"0b00001010000000000000000000000001", Auto, "10.0.0.1"},
+		{"167772161", Auto, "10.0.0.1"},
+		{"42540766411282592856903984951653826561", Auto, "2001:db8::1"},
+		{"20010db8000000000000000000000001", Auto, "2001:db8::1"},
+		{"10.1", Auto, "10.0.0.1"},
+		{"10.1.2", Auto, "10.1.0.2"},
+		{"012.0.0.1", Auto, "10.0.0.1"},
+		{"0x0a.0.0.1", Auto, "10.0.0.1"},
+		{"10.0.0.1", Hex, "16.0.0.1"},
+		{"0a:00:00:01", Hex, "10.0.0.1"},
+		{"8193:3512:0:0:0:0:0:1", Decimal, "2001:db8::1"},
+		{"012.0.0.01", Octal, "10.0.0.1"},
+		{"020001:06670:0:0:0:0:0:01", Octal, "2001:db8::1"},
+		{"1", Integer, "0.0.0.1"},
+		{"4294967296", Integer, "::1:0:0"},
+	}
+	for _, c := range cases {
+		ip, err := ParseIP(c.in, c.from)
+		if err != nil {
+			t.Errorf("[%s] (%s): %s\n", c.in, c.from, err)
+		} else if ip.String() != c.want {
+			t.Errorf("[%s] (%s) = [%s] (%s)\n", c.in, c.from, ip.String(), c.want)
+		}
+	}
+
+	/* Whatever Prefix writes reads back, padded or not. */
+	for _, in := range []string{"10.0.0.1", "2001:db8::1"} {
+		for _, f := range []Format{Binary, Hex, Decimal, Octal} {
+			for _, pad := range []bool{false, true} {
+				out, err := ConvertIPOptions(in, f, &Options{Prefix: true, Pad: pad})
+				if err != nil {
+					t.Fatalf("ConvertIPOptions(%s, %s): %s\n", in, f, err)
+				}
+				froms := []Format{f}
+				/* Auto takes zero-padded decimal as inet_aton octal. */
+				if (in == "10.0.0.1" && !(f == Decimal && pad)) || f == Binary || f == Hex {
+					froms = append(froms, Auto)
+				}
+				for _, from := range froms {
+					if ip, err := ParseIP(out, from); err != nil || ip.String() != in {
+						t.Errorf("[%s] (%s) = [%s], %v (%s)\n", out, from, ip, err, in)
+					}
+				}
+			}
+		}
+	}
+
+	for _, in := range []string{"", "bogus", "10.256.0.1", "256.1", "1.2.3.4.5", "0a0001", "1.2.3", "340282366920938463463374607431768211456"} {
+		from := Auto
+		if in == "1.2.3" {
+			from = Decimal
+		}
+		if ip, err := ParseIP(in, from); err == nil {
+			t.Errorf("[%s] = [%s], want error\n", in, ip)
+		}
+	}
+}
+
+func TestConvertIPOptions(t *testing.T) {
+	cases := []struct {
+		in        string
+		convertTo Format
+		o         Options
+		want      string
+	}{
+		{"10.0.0.1", Hex, Options{Prefix: true, Separator: ", ", Pad: true}, "0x0a, 0x00, 0x00, 0x01"},
+		{"10.0.0.1", Hex, Options{Pad: true, Separator: ":"}, "0a:00:00:01"},
+		{"10.0.0.1", Hex, Options{Pad: true, GroupBits: 16, Separator: "_", Upper: true}, "0A00_0001"},
+		{"10.0.0.1", Hex, Options{Pad: true, GroupBits: 32}, "0a000001"},
+		{"10.0.0.1", Hex, Options{}, "a.0.0.1"},
+		{"10.0.0.1", Binary, Options{Pad: true, NoSeparator: true, Prefix: true, GroupBits: 32}, "0b00001010000000000000000000000001"},
+		{"10.0.0.1", Decimal, Options{Pad: true}, "010.000.000.001"},
+		{"10.0.0.1", Octal, Options{}, "12.0.0.1"},
+		{"10.0.0.1", Octal, Options{Pad: true, Prefix: true}, "012.000.000.001"},
+		{"2001:db8::1", Hex, Options{Pad: true, GroupBits: 8, Separator: " "}, "20 01 0d b8 00 00 00 00 00 00 00 00 00 00 00 01"},
+		{"2001:db8::1", Hex, Options{Pad: true, GroupBits: 128}, "20010db8000000000000000000000001"},
+	}
+	for _, c := range cases {
+		o := c.o
+		got, err := ConvertIPOptions(c.in, c.convertTo, &o)
+		if err != nil {
+			t.Errorf("[%s] (%s) %+v: %s\n", c.in, c.convertTo, c.o, err)
+		} else if got != c.want {
+			t.Errorf("[%s] (%s) %+v = [%s] (%s)\n", c.in, c.convertTo, c.o, got, c.want)
+		}
+	}
+
+	if _, err := ConvertIPOptions("10.0.0.1", Hex, &Options{GroupBits: 12}); err == nil {
+		t.Errorf("12-bit groups of an IPv4 address, want error\n")
+	}
+}
+
+func TestConvertIPCompress(t *testing.T) {
+	cases := []struct {
+		in   string
+		o    Options
+		want string
+	}{
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", Options{Compress: true}, "2001:db8::1"},
+		{"2001:db8:0:0:1:0:0:1", Options{Compress: true}, "2001:db8::1:0:0:1"},
+		{"2001:db8:0:1:1:1:1:1", Options{Compress: true}, "2001:db8:0:1:1:1:1:1"},
+		{"2001:DB8:0:0:0::1", Options{Compress: true}, "2001:db8::1"},
+		{"::", Options{Compress: true}, "::"},
+		{"1::", Options{Compress: true}, "1::"},
+		{"10.0.0.1", Options{Compress: true}, "::ffff:10.0.0.1"},
+		{"::ffff:1.2.3.4", Options{Compress: true}, "::ffff:1.2.3.4"},
+		{"::ffff:1.2.3.4", Options{Compress: true, Upper: true, Pad: true}, "::ffff:1.2.3.4"},
+		{"10.0.0.1", Options{Compress: true, EmbedIPv4: true}, "::ffff:10.0.0.1"},
+		{"::ffff:10.0.0.1", Options{Compress: true, EmbedIPv4: true}, "::ffff:10.0.0.1"},
+		{"10.0.0.1", Options{EmbedIPv4: true}, "0000:0000:0000:0000:0000:ffff:10.0.0.1"},
+		{"64:ff9b::c000:221", Options{Compress: true, EmbedIPv4: true}, "64:ff9b::192.0.2.33"},
+		{"2001:db8:1:2:3:4:5:6", Options{Compress: true, EmbedIPv4: true}, "2001:db8:1:2:3:4:0.5.0.6"},
+	}
+	for _, c := range cases {
+		o := c.o
+		got, err := ConvertIPOptions(c.in, Hex, &o)
+		if err != nil {
+			t.Errorf("[%s] %+v: %s\n", c.in, c.o, err)
+		} else if got != c.want {
+			t.Errorf("[%s] %+v = [%s] (%s)\n", c.in, c.o, got, c.want)
+		}
+	}
+
+	ip := net.ParseIP("2001:db8::1")
+	if got := Expanded(ip); got != "2001:0db8:0000:0000:0000:0000:0000:0001" {
+		t.Errorf("Expanded(%s) = [%s]\n", ip, got)
+	}
+	if got := Canonical(net.ParseIP("192.0.2.1")); got != "::ffff:192.0.2.1" {
+		t.Errorf("Canonical(192.0.2.1) = [%s]\n", got)
+	}
+}
+
+func TestCalcSubnet(t *testing.T) {
+	cases := []struct {
+		in                                    string
+		network, broadcast, netmask, wildcard string
+		first, last, hosts                    string
+	}{
+		{"192.168.1.77/24", "192.168.1.0", "192.168.1.255", "255.255.255.0", "0.0.0.255", "192.168.1.1", "192.168.1.254", "254"},
+		{"10.1.2.3/31", "10.1.2.2", "10.1.2.3", "255.255.255.254", "0.0.0.1", "10.1.2.2", "10.1.2.3", "2"},
+		{"10.1.2.3", "10.1.2.3", "10.1.2.3", "255.255.255.255", "0.0.0.0", "10.1.2.3", "10.1.2.3", "1"},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255", "0.0.0.0", "255.255.255.255", "0.0.0.1", "255.255.255.254", "4294967294"},
+		{"2001:db8::1/64", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", "ffff:ffff:ffff:ffff::", "::ffff:ffff:ffff:ffff", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", "18446744073709551616"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0", "10.255.255.255", "255.0.0.0", "0.255.255.255", "10.0.0.1", "10.255.255.254", "16777214"},
+	}
+	for _, c := range cases {
+		s, err := CalcSubnet(c.in)
+		if err != nil {
+			t.Errorf("[%s]: %s\n", c.in, err)
+			continue
+		}
+		got := []string{s.Network.String(), s.Broadcast.String(), s.Netmask.String(), s.Wildcard.String(),
+			s.FirstHost.String(), s.LastHost.String(), s.Hosts.String()}
+		want := []string{c.network, c.broadcast, c.netmask, c.wildcard, c.first, c.last, c.hosts}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("[%s] = %v (%v)\n", c.in, got, want)
+				break
+			}
+		}
+	}
+
+	s, _ := CalcSubnet("192.168.1.77/20")
+	if got := s.Binary(s.Ip); got != "11000000.10101000.0000 0001.01001101" {
+		t.Errorf("Binary(%s) = [%s]\n", s.Ip, got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	upper, err := Register("Shout", func(ip net.IP, o *Options) (string, error) {
+		return strings.ToUpper(Expanded(ip)), nil
+	})
+	if err != nil {
+		t.Fatalf("Register: %s\n", err)
+	}
+	defer unregister(upper)
+	if f, err := ParseFormat("shout"); err != nil || f != upper {
+		t.Errorf("ParseFormat(shout) = %s, %v (%s)\n", f, err, upper)
+	}
+	if got, err := ConvertIP("2001:db8::ab", upper); err != nil || got != "2001:0DB8:0000:0000:0000:0000:0000:00AB" {
+		t.Errorf("ConvertIP(2001:db8::ab, shout) = [%s], %v\n", got, err)
+	}
+	if _, err := Register("hex", formatHex); err == nil {
+		t.Errorf("Register(hex), want error\n")
+	}
+	if _, err := Register("nothing", nil); err == nil {
+		t.Errorf("Register(nothing, nil), want error\n")
+	}
+	if _, err := ParseIP("10.0.0.1", upper); err == nil {
+		t.Errorf("ParseIP from shout, want error\n")
+	}
+	if _, err := ConvertIP("10.0.0.1", Format(1000)); err == nil {
+		t.Errorf("ConvertIP to Format(1000), want error\n")
+	}
+	if f, err := ParseFormat("Octal"); err != nil || f != Octal {
+		t.Errorf("ParseFormat(Octal) = %s, %v\n", f, err)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"1.2.3.4", "4.3.2.1.in-addr.arpa"},
+		{"::ffff:192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, c := range cases {
+		got, err := ConvertIP(c.in, Reverse)
+		if err != nil {
+			t.Errorf("[%s]: %s\n", c.in, err)
+			continue
+		} else if got != c.want {
+			t.Errorf("[%s] = [%s] (%s)\n", c.in, got, c.want)
+		}
+		for _, name := range []string{got, strings.ToUpper(got) + "."} {
+			ip, err := ParseIP(name, Auto)
+			if err != nil {
+				t.Errorf("[%s]: %s\n", name, err)
+			} else if !ip.Equal(net.ParseIP(c.in)) {
+				t.Errorf("[%s] = [%s] (%s)\n", name, ip, c.in)
+			}
+		}
+	}
+
+	for _, in := range []string{"3.2.1.in-addr.arpa", "256.3.2.1.in-addr.arpa", "04.3.2.1.in-addr.arpa", "1.0.ip6.arpa", "4.3.2.1.example.com"} {
+		if ip, err := ParseIP(in, Reverse); err == nil {
+			t.Errorf("[%s] = [%s], want error\n", in, ip)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		in    string
+		kind  string
+		name  string
+		scope string
+	}{
+		{"10.1.2.3", "private", "Private-Use", ""},
+		{"100.64.0.1", "cgnat", "Shared Address Space", ""},
+		{"127.0.0.1", "loopback", "Loopback", ""},
+		{"169.254.1.1", "link-local", "Link Local", ""},
+		{"192.0.0.9", "anycast", "Port Control Protocol Anycast", ""},
+		{"192.0.0.100", "protocol", "IETF Protocol Assignments", ""},
+		{"198.51.100.7", "documentation", "Documentation (TEST-NET-2)", ""},
+		{"224.0.0.251", "multicast", "Local Network Control Block", "link-local"},
+		{"239.255.255.250", "multicast", "Administratively Scoped Block", "site-local"},
+		{"255.255.255.255", "broadcast", "Limited Broadcast", ""},
+		{"8.8.8.8", "", "", ""},
+		{"::1", "loopback", "Loopback Address", ""},
+		{"2001:db8::1", "documentation", "Documentation", ""},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", "teredo", "TEREDO", ""},
+		{"2002:c000:204::1", "6to4", "6to4", ""},
+		{"64:ff9b::192.0.2.33", "nat64", "IPv4-IPv6 Translat.", ""},
+		{"fd12:3456::1", "ula", "Unique-Local", ""},
+		{"fe80::1", "link-local", "Link-Local Unicast", ""},
+		{"ff02::fb", "multicast", "Multicast", "link-local"},
+		{"ff0e::1", "multicast", "Multicast", "global"},
+		{"2606:4700::1111", "", "", ""},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.in)
+		found := Classify(ip)
+		kind, name := "", ""
+		if len(found) > 0 {
+			kind, name = found[0].Kind, found[0].Name
+		}
+		if kind != c.kind || name != c.name {
+			t.Errorf("[%s] = %s %q (%s %q)\n", c.in, kind, name, c.kind, c.name)
+		}
+		if got := MulticastScope(ip); got != c.scope {
+			t.Errorf("MulticastScope(%s) = [%s] (%s)\n", c.in, got, c.scope)
+		}
+	}
+
+	if found := Classify(net.ParseIP("192.0.0.9")); len(found) != 2 || found[1].Prefix.String() != "192.0.0.0/24" {
+		t.Errorf("Classify(192.0.0.9) = %v, want the /32 then the /24\n", found)
+	}
+	if IsGlobal(net.ParseIP("192.168.1.1")) || !IsGlobal(net.ParseIP("8.8.8.8")) || !IsGlobal(net.ParseIP("192.0.0.9")) {
+		t.Errorf("IsGlobal disagrees with the registry\n")
+	}
+}
+
+func TestExtractIPv4(t *testing.T) {
+	_, custom, _ := net.ParseCIDR("2001:db8:100::/40")
+	cases := []struct {
+		in     string
+		kind   string
+		ipv4   string
+		server string
+		port   uint16
+	}{
+		{"::ffff:192.0.2.1", "mapped", "192.0.2.1", "", 0},
+		{"::192.0.2.1", "compatible", "192.0.2.1", "", 0},
+		{"2002:c000:204::1", "6to4", "192.0.2.4", "", 0},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", "teredo", "192.0.2.45", "65.54.227.120", 40000},
+		{"64:ff9b::c000:221", "nat64", "192.0.2.33", "", 0},
+		{"64:ff9b:1:c000:2:2100::", "nat64", "192.0.2.33", "", 0},
+		{"2001:db8:1c0:2:21::", "nat64", "192.0.2.33", "", 0},
+		{"fe80::5efe:c000:201", "isatap", "192.0.2.1", "", 0},
+		{"2001:db8::200:5efe:c000:201", "isatap", "192.0.2.1", "", 0},
+		{"2001:db8::1", "", "", "", 0},
+		{"::1", "", "", "", 0},
+	}
+	for _, c := range cases {
+		e := ExtractIPv4(net.ParseIP(c.in), custom)
+		if e == nil {
+			if c.kind != "" {
+				t.Errorf("[%s] = nil (%s %s)\n", c.in, c.kind, c.ipv4)
+			}
+			continue
+		}
+		if e.Kind != c.kind || e.Ipv4.String() != c.ipv4 || (c.server != "" && e.Server.String() != c.server) || e.Port != c.port {
+			t.Errorf("[%s] = %s %s %s %d (%s %s %s %d)\n", c.in, e.Kind, e.Ipv4, e.Server, e.Port, c.kind, c.ipv4, c.server, c.port)
+		}
+	}
+
+	if got, err := NAT64Address(custom, net.ParseIP("192.0.2.33")); err != nil || got.String() != "2001:db8:1c0:2:21::" {
+		t.Errorf("NAT64Address(%s) = %s, %v\n", custom, got, err)
+	}
+	_, bad, _ := net.ParseCIDR("2001:db8::/36")
+	if _, err := NAT64Address(bad, net.ParseIP("192.0.2.33")); err == nil {
+		t.Errorf("NAT64Address(%s), want error\n", bad)
+	}
+}
+
+func TestEUI64(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1:2::/64")
+	cases := []struct {
+		mac  string
+		want string
+	}{
+		{"00:1a:2b:3c:4d:5e", "2001:db8:1:2:21a:2bff:fe3c:4d5e"},
+		{"02:00:5e:10:00:01", "2001:db8:1:2:0:5eff:fe10:1"},
+		{"00-1A-2B-3C-4D-5E", "2001:db8:1:2:21a:2bff:fe3c:4d5e"},
+	}
+	for _, c := range cases {
+		mac, err := net.ParseMAC(c.mac)
+		if err != nil {
+			t.Fatalf("ParseMAC(%s): %s\n", c.mac, err)
+		}
+		ip, err := SLAAC(prefix, mac)
+		if err != nil {
+			t.Errorf("[%s]: %s\n", c.mac, err)
+			continue
+		} else if ip.String() != c.want {
+			t.Errorf("[%s] = [%s] (%s)\n", c.mac, ip, c.want)
+		}
+		if back, err := MACFromIP(ip); err != nil || back.String() != mac.String() {
+			t.Errorf("MACFromIP(%s) = %s, %v (%s)\n", ip, back, err, mac)
+		}
+	}
+
+	if _, err := MACFromIP(net.ParseIP("2001:db8::1")); err == nil {
+		t.Errorf("MACFromIP(2001:db8::1), want error\n")
+	}
+	_, wide, _ := net.ParseCIDR("2001:db8::/48")
+	if _, err := SLAAC(wide, net.HardwareAddr{0, 1, 2, 3, 4, 5}); err == nil {
+		t.Errorf("SLAAC(%s), want error\n", wide)
+	}
+	if id, err := InterfaceID(net.HardwareAddr{0, 1, 2, 3, 4, 5, 6, 7}); err != nil || id[0] != 2 || id[7] != 7 {
+		t.Errorf("InterfaceID(64-bit) = %x, %v\n", id, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"Accepted publickey for root from 192.0.2.7 port 52110 ssh2", []string{"192.0.2.7"}},
+		{`{"src":"10.0.0.1","dst":"2001:db8::1"}`, []string{"10.0.0.1", "2001:db8::1"}},
+		{"route 10.0.0.0/8 via 10.1.1.1.", []string{"10.0.0.0/8", "10.1.1.1"}},
+		{"listen 10.0.0.1:8080 and [2001:db8::1]:443", []string{"10.0.0.1", "2001:db8::1"}},
+		{"client:203.0.113.9 ::ffff:192.0.2.1 fe80::1/64", []string{"203.0.113.9", "::ffff:192.0.2.1", "fe80::1/64"}},
+		{"version 1.2.3.4.5, mac 00:1a:2b:3c:4d:5e at 12:30:45", nil},
+		{"std::cout << x10.0.0.1 << 10.0.0.1x << deadbeef << :: << 2024", nil},
+		{"10.0.0.256 999.1.1.1 10.0.0.1/33", []string{"10.0.0.1"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, m := range Scan(c.in) {
+			if c.in[m.Start:m.End] != m.Text {
+				t.Errorf("[%s]: match %q at %d-%d\n", c.in, m.Text, m.Start, m.End)
+			}
+			got = append(got, m.Text)
+		}
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("[%s] = %q (%q)\n", c.in, got, c.want)
+		}
+	}
+
+	in := "from 10.0.0.1 to 10.0.0.0/24."
+	got := ReplaceAll(in, func(m Match) string {
+		s, _ := ConvertIP(m.Text, Hex)
+		return s
+	})
+	if want := "from 0a.00.00.01 to 0a.00.00.00."; got != want {
+		t.Errorf("ReplaceAll(%s) = [%s] (%s)\n", in, got, want)
+	}
+
+	/* Annotating MACs leaves addresses without one as they were. */
+	in = "neighbor 10.0.0.1 via fe80::21a:2bff:fe3c:4d5e and 2001:db8::1, 192.0.2.7"
+	got = ReplaceAll(in, func(m Match) string {
+		if mac, err := MACFromIP(m.Ip); err == nil {
+			return m.Text + " [mac " + mac.String() + "]"
+		}
+		return m.Text
+	})
+	if want := "neighbor 10.0.0.1 via fe80::21a:2bff:fe3c:4d5e [mac 00:1a:2b:3c:4d:5e] and 2001:db8::1, 192.0.2.7"; got != want {
+		t.Errorf("ReplaceAll(%s) = [%s] (%s)\n", in, got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package convert
+
+import (
+	"fmt"
+	"net"
+)
+
+/* An IPv4 address found inside an IPv6 transition address. */
+type Embedded struct {
+	Kind   string     /* One of "mapped", "compatible", "6to4", "teredo", "nat64" or "isatap". */
+	Ipv4   net.IP     /* The embedded address; for Teredo, the client's public address. */
+	Prefix *net.IPNet /* The NAT64 prefix, or the /48 (6to4) or /64 (ISATAP) it's under. */
+	Server net.IP     /* Teredo server. */
+	Port   uint16     /* Teredo client's public port. */
+	Flags  uint16     /* Teredo flags, with 0x8000 the cone bit. */
+}
+
+/* The RFC 6052 well-known prefix and the RFC 8215 local-use prefix. */
+var nat64Prefixes = []*net.IPNet{
+	{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)},
+	{IP: net.ParseIP("64:ff9b:1::"), Mask: net.CIDRMask(48, 128)},
+}
+
+/*
+ * Extracts the IPv4 address embedded in ip, if it's an IPv4-mapped,
+ * IPv4-compatible, 6to4, Teredo, NAT64 or ISATAP address. NAT64 uses the
+ * well-known prefixes plus any given in nat64 (lengths 32, 40, 48, 56, 64 or
+ * 96, as in RFC 6052). Returns nil for a 4-byte ip or anything else.
+ */
+func ExtractIPv4(ip net.IP, nat64 ...*net.IPNet) *Embedded {
+	if len(ip) != net.IPv6len {
+		return nil
+	}
+	v4 := func(b []byte) net.IP {
+		return net.IPv4(b[0], b[1], b[2], b[3]).To4()
+	}
+
+	prefixes := append(append([]*net.IPNet{}, nat64...), nat64Prefixes...)
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			if v, err := nat64Extract(ip, prefix); err == nil {
+				return &Embedded{Kind: "nat64", Ipv4: v, Prefix: prefix}
+			}
+		}
+	}
+
+	switch {
+	case isZero(ip[:10]) && ip[10] == 0xff && ip[11] == 0xff:
+		return &Embedded{Kind: "mapped", Ipv4: v4(ip[12:])}
+	case isZero(ip[:12]):
+		/* :: and ::1 aren't IPv4-compatible addresses. */
+		if isZero(ip[12:15]) && ip[15] <= 1 {
+			return nil
+		}
+		return &Embedded{Kind: "compatible", Ipv4: v4(ip[12:])}
+	case ip[0] == 0x20 && ip[1] == 0x02:
+		return &Embedded{Kind: "6to4", Ipv4: v4(ip[2:6]), Prefix: &net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}}
+	case ip[0] == 0x20 && ip[1] == 0x01 && ip[2] == 0 && ip[3] == 0:
+		/* RFC 4380: the client's port and address are stored inverted. */
+		client := make([]byte, 4)
+		for i := range client {
+			client[i] = ^ip[12+i]
+		}
+		return &Embedded{
+			Kind:   "teredo",
+			Ipv4:   v4(client),
+			Server: v4(ip[4:8]),
+			Port:   ^(uint16(ip[10])<<8 | uint16(ip[11])),
+			Flags:  uint16(ip[8])<<8 | uint16(ip[9]),
+		}
+	case ip[8]&^0x02 == 0 && ip[9] == 0 && ip[10] == 0x5e && ip[11] == 0xfe:
+		/* RFC 5214 interface ID, 0000:5efe or (with the u/l bit) 0200:5efe. */
+		return &Embedded{Kind: "isatap", Ipv4: v4(ip[12:]), Prefix: &net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}}
+	}
+	return nil
+}
+
+/*
+ * Builds the RFC 6052 address for v4 under a NAT64 prefix of length 32, 40,
+ * 48, 56, 64 or 96, skipping bits 64-71 (the "u" octet).
+ */
+func NAT64Address(prefix *net.IPNet, v4 net.IP) (net.IP, error) {
+	idx, err := nat64Octets(prefix)
+	if err != nil {
+		return nil, err
+	}
+	p4 := v4.To4()
+	if p4 == nil {
+		return nil, fmt.Errorf("not an IPv4 address [%s]", v4)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16().Mask(prefix.Mask))
+	for i, j := range idx {
+		ip[j] = p4[i]
+	}
+	return ip, nil
+}
+
+func nat64Extract(ip net.IP, prefix *net.IPNet) (net.IP, error) {
+	idx, err := nat64Octets(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ip[8] != 0 {
+		return nil, fmt.Errorf("non-zero u octet in [%s]", ip)
+	}
+	v4 := make(net.IP, net.IPv4len)
+	for i, j := range idx {
+		v4[i] = ip[j]
+	}
+	return v4, nil
+}
+
+/* Where the four IPv4 octets go for an RFC 6052 prefix. */
+func nat64Octets(prefix *net.IPNet) ([]int, error) {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 {
+		return nil, fmt.Errorf("not an IPv6 prefix [%s]", prefix)
+	}
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("NAT64 prefix [%s] must be /32, /40, /48, /56, /64 or /96", prefix)
+	}
+	idx := make([]int, 0, net.IPv4len)
+	for j := ones / 8; len(idx) < net.IPv4len; j++ {
+		if j != 8 {
+			idx = append(idx, j)
+		}
+	}
+	return idx, nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package convert
+
+import (
+	"fmt"
+	"net"
+)
+
+/*
+ * Returns the modified EUI-64 interface identifier for a MAC (RFC 4291
+ * appendix A): ff:fe goes in the middle of a 48-bit MAC and the
+ * universal/local bit is inverted. 64-bit EUI-64 addresses just get the bit
+ * inverted.
+ */
+func InterfaceID(mac net.HardwareAddr) ([]byte, error) {
+	id := make([]byte, 8)
+	switch len(mac) {
+	case 6:
+		copy(id, mac[:3])
+		id[3], id[4] = 0xff, 0xfe
+		copy(id[5:], mac[3:])
+	case 8:
+		copy(id, mac)
+	default:
+		return nil, fmt.Errorf("not a 48- or 64-bit MAC [%s]", mac)
+	}
+	id[0] ^= 0x02
+	return id, nil
+}
+
+/* Returns the SLAAC address for mac under a /64 prefix. */
+func SLAAC(prefix *net.IPNet, mac net.HardwareAddr) (net.IP, error) {
+	if ones, bits := prefix.Mask.Size(); ones != 64 || bits != 128 {
+		return nil, fmt.Errorf("SLAAC needs an IPv6 /64, not [%s]", prefix)
+	}
+	id, err := InterfaceID(mac)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16().Mask(prefix.Mask))
+	copy(ip[8:], id)
+	return ip, nil
+}
+
+/* True if ip's interface identifier was made from a 48-bit MAC (it has ff:fe in the middle). */
+func IsEUI64(ip net.IP) bool {
+	p := ip.To16()
+	return p != nil && ip.To4() == nil && p[11] == 0xff && p[12] == 0xfe
+}
+
+/* Recovers the 48-bit MAC from an address whose interface identifier is a modified EUI-64. */
+func MACFromIP(ip net.IP) (net.HardwareAddr, error) {
+	if !IsEUI64(ip) {
+		return nil, fmt.Errorf("no EUI-64 interface identifier in [%s]", ip)
+	}
+	p := ip.To16()
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, p[8:11])
+	copy(mac[3:], p[13:16])
+	mac[0] ^= 0x02
+	return mac, nil
+}
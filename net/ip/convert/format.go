@@ -0,0 +1,141 @@
+package convert
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+/* An output format for ConvertIP, either built in or added with Register. */
+type Format int
+
+const (
+	Binary Format = iota
+	Hex
+	Decimal /* Dotted decimal. */
+	Integer /* The whole address as one unsigned integer. */
+	Octal   /* Dotted octal, with the leading zeros inet_aton expects. */
+	Reverse /* The PTR query name, under in-addr.arpa or ip6.arpa. */
+)
+
+/* Tells ParseIP to work out the input format itself. */
+const Auto Format = -1
+
+/*
+ * Renders an address: 4 bytes for IPv4 (including IPv4-mapped input), 16 for
+ * IPv6. o is never nil.
+ */
+type Formatter func(ip net.IP, o *Options) (string, error)
+
+type namedFormatter struct {
+	name string
+	fn   Formatter
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = []namedFormatter{
+		Binary:  {"binary", groupFormatter(2)},
+		Hex:     {"hex", formatHex},
+		Decimal: {"decimal", groupFormatter(10)},
+		Integer: {"integer", formatInteger},
+		Octal:   {"octal", groupFormatter(8)},
+		Reverse: {"ptr", formatReverse},
+	}
+)
+
+/* Adds a named output format, returning the Format to pass to ConvertIP. */
+func Register(name string, fn Formatter) (Format, error) {
+	if fn == nil {
+		return 0, fmt.Errorf("no formatter for format [%s]", name)
+	}
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	name = strings.ToLower(name)
+	for _, f := range formats {
+		if f.name == name {
+			return 0, fmt.Errorf("format [%s] is already registered", name)
+		}
+	}
+	formats = append(formats, namedFormatter{name, fn})
+	return Format(len(formats) - 1), nil
+}
+
+/* Drops f if it was the last format registered, so tests can clean up after themselves. */
+func unregister(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if int(f) == len(formats)-1 && f > Reverse {
+		formats = formats[:f]
+	}
+}
+
+/* Looks a format up by name, case insensitively. */
+func ParseFormat(name string) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	name = strings.ToLower(name)
+	if name == "auto" {
+		return Auto, nil
+	}
+	for i, f := range formats {
+		if f.name == name {
+			return Format(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format [%s]", name)
+}
+
+/* Names of every registered format, in the order they were added. */
+func FormatNames() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.name
+	}
+	return names
+}
+
+func (f Format) String() string {
+	if f == Auto {
+		return "auto"
+	}
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	if f < 0 || int(f) >= len(formats) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formats[f].name
+}
+
+func (f Format) formatter() (Formatter, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	if f < 0 || int(f) >= len(formats) {
+		return nil, fmt.Errorf("unknown format %d", int(f))
+	}
+	return formats[f].fn, nil
+}
+
+func groupFormatter(base int) Formatter {
+	return func(ip net.IP, o *Options) (string, error) {
+		return formatGroups(ip, base, o)
+	}
+}
+
+func formatHex(ip net.IP, o *Options) (string, error) {
+	switch {
+	case o.Compress && len(ip) == net.IPv4len:
+		/* Mapped input arrives as 4 bytes too; RFC 5952 writes both as ::ffff:a.b.c.d. */
+		return Canonical(ip), nil
+	case o.EmbedIPv4 || o.Compress:
+		return formatIPv6(ip.To16(), o.Compress, o.EmbedIPv4 || len(ip) == net.IPv4len), nil
+	}
+	return formatGroups(ip, 16, o)
+}
+
+func formatInteger(ip net.IP, o *Options) (string, error) {
+	return ToBigInt(ip).String(), nil
+}
@@ -0,0 +1,225 @@
+package convert
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var maxIPv4 = big.NewInt(0xffffffff)
+var maxIPv6 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+/*
+ * The reverse of ConvertIP: parses an address written in the given format
+ * (Binary, Hex, Decimal, Integer, Octal, Reverse or Auto). Integers that
+ * fit in 32 bits are taken as IPv4.
+ *
+ * Auto accepts regular IPv4/IPv6 text, undotted or dotted binary (32 or 128
+ * bits), hex with letters in it or a 0x prefix, plain integers, and the
+ * legacy inet_aton forms ("10.1", "012.0.0.1", "0x0a.0.0.1") and PTR names
+ * ("1.0.0.10.in-addr.arpa"). Dotted hex
+ * like "0a.00.00.01" is only recognized when some part has a letter; use
+ * an explicit format for anything ambiguous.
+ */
+func ParseIP(in string, from Format) (net.IP, error) {
+	s := strings.TrimSpace(in)
+	if s == "" {
+		return nil, fmt.Errorf("couldn't parse ip [%s]", in)
+	}
+
+	var ip net.IP
+	var err error
+	switch from {
+	case Auto:
+		ip, err = parseAuto(s)
+	case Binary:
+		ip, err = parseGrouped(s, 2, 1)
+	case Hex:
+		if strings.ContainsRune(s, '.') {
+			ip, err = parseGroups(s, 16)
+		} else if ip = net.ParseIP(s); ip == nil {
+			ip, err = parseGrouped(s, 16, 4)
+		}
+	case Decimal:
+		ip, err = parseGroups(s, 10)
+	case Octal:
+		ip, err = parseGroups(s, 8)
+	case Integer:
+		ip, err = parseInteger(s)
+	case Reverse:
+		ip, err = parseReverse(s)
+	default:
+		err = fmt.Errorf("can't parse from format %s", from)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse ip [%s]: %s", in, err)
+	}
+	return ip, nil
+}
+
+func parseAuto(s string) (net.IP, error) {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(strings.TrimSuffix(lower, "."), ".arpa"):
+		return parseReverse(s)
+	case strings.HasPrefix(lower, "0x") && !strings.ContainsAny(s, ".:"):
+		return parseInteger(s)
+	case strings.HasPrefix(lower, "0b"):
+		return parseGrouped(lower, 2, 1)
+	case strings.ContainsRune(s, ':'):
+		if ip := net.ParseIP(s); ip != nil {
+			return ip, nil
+		} else if strings.HasPrefix(lower, "0x") {
+			return parseGrouped(lower, 16, 4)
+		}
+		return parseDigits(s, 2, 1)
+	}
+
+	parts := strings.Split(lower, ".")
+	binary, hex, letters := true, true, false
+	for _, part := range parts {
+		binary = binary && len(part) == 8 && strings.Trim(part, "01") == ""
+		hex = hex && len(part) == 2 && strings.Trim(part, "0123456789abcdef") == ""
+		letters = letters || strings.ContainsAny(part, "abcdef")
+	}
+	switch {
+	case len(parts) == 4 && binary:
+		return parseDigits(lower, 2, 1)
+	case len(parts) == 4 && hex && letters:
+		return parseDigits(lower, 16, 4)
+	case len(parts) > 1:
+		if ip := net.ParseIP(s); ip != nil {
+			return ip, nil
+		}
+		return parseInetAton(parts)
+	case strings.Trim(lower, "01") == "" && (len(lower) == 32 || len(lower) == 128):
+		return parseDigits(lower, 2, 1)
+	case strings.Trim(lower, "0123456789") == "":
+		return parseInteger(lower)
+	case strings.Trim(lower, "0123456789abcdef") == "":
+		return parseDigits(lower, 16, 4)
+	}
+	return nil, fmt.Errorf("unrecognized format")
+}
+
+/* Strips separators (and group prefixes) and reads the remaining digits as a 32- or 128-bit address. */
+func parseDigits(s string, base, bitsPerDigit int) (net.IP, error) {
+	groups := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '.' || r == ':' || r == '_' || r == ' '
+	})
+	for i, g := range groups {
+		groups[i] = trimGroupPrefix(g, base)
+	}
+	digits := strings.Join(groups, "")
+	bits := len(digits) * bitsPerDigit
+	if bits != 32 && bits != 128 {
+		return nil, fmt.Errorf("%d bits, want 32 or 128", bits)
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, fmt.Errorf("bad base %d digits [%s]", base, digits)
+	}
+	return fromBigInt(n, bits == 32), nil
+}
+
+/*
+ * Reads the usual four or eight groups if that's what s has, which copes
+ * with unpadded groups, and otherwise runs all the digits together.
+ */
+func parseGrouped(s string, base, bitsPerDigit int) (net.IP, error) {
+	if ip, err := parseGroups(s, base); err == nil {
+		return ip, nil
+	}
+	return parseDigits(s, base, bitsPerDigit)
+}
+
+/* Reads four dotted or eight colon-separated groups in the given base. */
+func parseGroups(s string, base int) (net.IP, error) {
+	sep, count, size := ".", 4, 8
+	if strings.ContainsRune(s, ':') {
+		sep, count, size = ":", 8, 16
+	}
+	groups := strings.Split(s, sep)
+	if len(groups) != count {
+		return nil, fmt.Errorf("%d groups, want %d", len(groups), count)
+	}
+	ip := make(net.IP, count*size/8)
+	for i, g := range groups {
+		n, err := strconv.ParseUint(trimGroupPrefix(g, base), base, size)
+		if err != nil {
+			return nil, err
+		}
+		if size == 8 {
+			ip[i] = byte(n)
+		} else {
+			ip[2*i], ip[2*i+1] = byte(n>>8), byte(n)
+		}
+	}
+	return ip, nil
+}
+
+/* Drops the 0b or 0x that Options.Prefix puts on each binary or hex group. */
+func trimGroupPrefix(g string, base int) string {
+	lower := strings.ToLower(g)
+	if (base == 2 && strings.HasPrefix(lower, "0b")) || (base == 16 && strings.HasPrefix(lower, "0x")) {
+		return g[2:]
+	}
+	return g
+}
+
+/* Reads a decimal (or 0x-prefixed hex) integer. */
+func parseInteger(s string) (net.IP, error) {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("bad integer [%s]", s)
+	} else if n.Cmp(maxIPv6) > 0 {
+		return nil, fmt.Errorf("integer too large")
+	}
+	return fromBigInt(n, n.Cmp(maxIPv4) <= 0), nil
+}
+
+/*
+ * Reads 1-4 dotted parts like inet_aton(3): each part may be decimal, octal
+ * (leading 0) or hex (leading 0x), and the last part fills the remaining bytes.
+ */
+func parseInetAton(parts []string) (net.IP, error) {
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("too many parts")
+	}
+	ip := make(net.IP, net.IPv4len)
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad part [%s]", part)
+		}
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return nil, fmt.Errorf("part [%s] out of range", part)
+			}
+			ip[i] = byte(n)
+			continue
+		}
+		width := uint(net.IPv4len - i)
+		if width < 4 && n >= 1<<(8*width) {
+			return nil, fmt.Errorf("part [%s] out of range", part)
+		}
+		for j := net.IPv4len - 1; j >= i; j-- {
+			ip[j] = byte(n)
+			n >>= 8
+		}
+	}
+	return ip, nil
+}
+
+func fromBigInt(n *big.Int, v4 bool) net.IP {
+	size := net.IPv6len
+	if v4 {
+		size = net.IPv4len
+	}
+	ip := make(net.IP, size)
+	b := n.Bytes()
+	copy(ip[size-len(b):], b)
+	return ip
+}
@@ -0,0 +1,74 @@
+package convert
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Returns the PTR query name for ip: the octets reversed under in-addr.arpa
+ * for IPv4, the nibbles reversed under ip6.arpa for IPv6. No trailing dot.
+ */
+func ReverseName(ip net.IP) string {
+	if p4 := ip.To4(); p4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", p4[3], p4[2], p4[1], p4[0])
+	}
+	p := ip.To16()
+	const hexDigits = "0123456789abcdef"
+	buf := make([]byte, 0, 4*net.IPv6len+len("ip6.arpa"))
+	for i := len(p) - 1; i >= 0; i-- {
+		buf = append(buf, hexDigits[p[i]&0xf], '.', hexDigits[p[i]>>4], '.')
+	}
+	return string(append(buf, "ip6.arpa"...))
+}
+
+/* Parses a full in-addr.arpa or ip6.arpa name, with or without the trailing dot, back into an address. */
+func ParseReverseName(name string) (net.IP, error) {
+	ip, err := parseReverse(name)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse ptr name [%s]: %s", name, err)
+	}
+	return ip, nil
+}
+
+func parseReverse(name string) (net.IP, error) {
+	lower := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if s := strings.TrimSuffix(lower, ".in-addr.arpa"); s != lower {
+		labels := strings.Split(s, ".")
+		if len(labels) != net.IPv4len {
+			return nil, fmt.Errorf("%d labels, want 4", len(labels))
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, l := range labels {
+			n, err := strconv.ParseUint(l, 10, 8)
+			if err != nil || (len(l) > 1 && l[0] == '0') {
+				return nil, fmt.Errorf("bad label [%s]", l)
+			}
+			ip[net.IPv4len-1-i] = byte(n)
+		}
+		return ip, nil
+	}
+	if s := strings.TrimSuffix(lower, ".ip6.arpa"); s != lower {
+		labels := strings.Split(s, ".")
+		if len(labels) != 2*net.IPv6len {
+			return nil, fmt.Errorf("%d labels, want 32", len(labels))
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, l := range labels {
+			n, err := strconv.ParseUint(l, 16, 4)
+			if err != nil || len(l) != 1 {
+				return nil, fmt.Errorf("bad label [%s]", l)
+			}
+			/* Labels run from the lowest nibble up. */
+			ip[net.IPv6len-1-i/2] |= byte(n) << (4 * uint(i%2))
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("not under in-addr.arpa or ip6.arpa")
+}
+
+func formatReverse(ip net.IP, o *Options) (string, error) {
+	return ReverseName(ip), nil
+}
@@ -0,0 +1,121 @@
+package convert
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+/* An address or CIDR block found in text by Scan. */
+type Match struct {
+	Start, End int        /* Byte offsets, so Text is s[Start:End]. */
+	Text       string     /* As written, including any /length. */
+	Ip         net.IP     /* The address (the IP part of a CIDR block). */
+	Net        *net.IPNet /* The block, or nil for a bare address. */
+}
+
+/*
+ * Finds every IPv4 and IPv6 address, with or without a /length, in free
+ * text. Addresses have to stand apart from surrounding words, so version
+ * strings ("1.2.3.4.5"), MACs, times and identifiers like "std::cout" are
+ * left alone; trailing sentence punctuation and ports ("10.0.0.1:8080",
+ * "[2001:db8::1]:443") are not part of the match. Zone IDs aren't supported.
+ */
+func Scan(s string) []Match {
+	var found []Match
+	for i := 0; i < len(s); {
+		if !isAddrChar(s[i]) || (i > 0 && isWordChar(s[i-1])) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && isAddrChar(s[j]) {
+			j++
+		}
+		if m, ok := matchAt(s, i, j); ok {
+			found = append(found, m)
+			i = m.End
+			continue
+		}
+		/* Try again after the next colon, for things like "client:10.0.0.1". */
+		if k := strings.IndexByte(s[i:j], ':'); k >= 0 {
+			i += k + 1
+		} else {
+			i = j
+		}
+	}
+	return found
+}
+
+/* Replaces every match in s with fn's result, leaving the rest of the text as it was. */
+func ReplaceAll(s string, fn func(m Match) string) string {
+	var buf bytes.Buffer
+	last := 0
+	for _, m := range Scan(s) {
+		buf.WriteString(s[last:m.Start])
+		buf.WriteString(fn(m))
+		last = m.End
+	}
+	buf.WriteString(s[last:])
+	return buf.String()
+}
+
+/* Looks for the longest address at s[i:], within the run of address characters ending at j. */
+func matchAt(s string, i, j int) (Match, bool) {
+	for end := j; end > i; end-- {
+		/* The whole run can end before anything but a word; shorter ones only at a port or trailing dot. */
+		if end == j {
+			if j < len(s) && isWordChar(s[j]) {
+				continue
+			}
+		} else if s[end] != ':' && !(s[end] == '.' && standsApart(s, end)) {
+			continue
+		}
+		text := s[i:end]
+		if !strings.ContainsAny(text, "0123456789abcdefABCDEF") {
+			continue
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			continue
+		}
+		if p4 := ip.To4(); p4 != nil && !strings.ContainsRune(text, ':') {
+			ip = p4
+		}
+		m := Match{Start: i, End: end, Text: text, Ip: ip}
+		if end < len(s) && s[end] == '/' {
+			k := end + 1
+			for k < len(s) && k-end <= 3 && s[k] >= '0' && s[k] <= '9' {
+				k++
+			}
+			if k > end+1 && standsApart(s, k) {
+				if _, ipnet, err := net.ParseCIDR(s[i:k]); err == nil {
+					m.End, m.Text, m.Net = k, s[i:k], ipnet
+				}
+			}
+		}
+		return m, true
+	}
+	return Match{}, false
+}
+
+/* True if nothing at s[k:] joins onto the text before it, allowing for a full stop. */
+func standsApart(s string, k int) bool {
+	if k < len(s) && s[k] == '.' {
+		k++
+	}
+	return k == len(s) || !isWordChar(s[k])
+}
+
+func isAddrChar(c byte) bool {
+	return c == '.' || c == ':' || isHexDigit(c)
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+/* Characters that join an address onto the word before or after it. */
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+/* Everything an ipcalc-style subnet calculator reports about a CIDR block. */
+type Subnet struct {
+	Ip        net.IP   /* The address as given, before masking. */
+	Ones      int      /* Prefix length. */
+	Bits      int      /* Address length, 32 or 128. */
+	Network   net.IP   /* First address. */
+	Broadcast net.IP   /* Last address (IPv6 has no broadcast, but it's still the last address). */
+	Netmask   net.IP   /* The mask as an address. */
+	Wildcard  net.IP   /* The inverted mask, as used by Cisco ACLs. */
+	FirstHost net.IP   /* First usable host address. */
+	LastHost  net.IP   /* Last usable host address. */
+	Addresses *big.Int /* Addresses in the block. */
+	Hosts     *big.Int /* Usable host addresses. */
+}
+
+/*
+ * Calculates the subnet for a CIDR block (or a bare IP, as a /32 or /128).
+ * IPv4 blocks lose their network and broadcast addresses to hosts, except
+ * for /31 (RFC 3021) and /32. IPv6 blocks are all usable.
+ */
+func CalcSubnet(inIp string) (*Subnet, error) {
+	var s Subnet
+	var mask net.IPMask
+	if ip := net.ParseIP(inIp); ip != nil {
+		if p4 := ip.To4(); p4 != nil && !strings.ContainsRune(inIp, ':') {
+			ip = p4
+		}
+		s.Ip = ip
+		mask = net.CIDRMask(8*len(ip), 8*len(ip))
+	} else {
+		ip, ipnet, err := net.ParseCIDR(inIp)
+		if err != nil {
+			return nil, err
+		}
+		s.Ip = ip
+		mask = ipnet.Mask
+		if len(mask) == net.IPv4len {
+			s.Ip = ip.To4()
+		} else if p4 := ip.To4(); p4 != nil {
+			/* An IPv4-mapped block (::ffff:a.b.c.d/n) is the IPv4 block /n-96. */
+			if ones, _ := mask.Size(); ones >= 96 {
+				s.Ip = p4
+				mask = net.CIDRMask(ones-96, 32)
+			}
+		}
+	}
+	s.Ones, s.Bits = mask.Size()
+
+	s.Network = s.Ip.Mask(mask)
+	s.Netmask = net.IP(mask)
+	s.Wildcard = make(net.IP, len(mask))
+	s.Broadcast = make(net.IP, len(mask))
+	for i := range mask {
+		s.Wildcard[i] = ^mask[i]
+		s.Broadcast[i] = s.Network[i] | ^mask[i]
+	}
+
+	hostBits := uint(s.Bits - s.Ones)
+	s.Addresses = new(big.Int).Lsh(big.NewInt(1), hostBits)
+	s.Hosts = new(big.Int).Set(s.Addresses)
+	s.FirstHost, s.LastHost = s.Network, s.Broadcast
+	if s.Bits == 32 && hostBits > 1 {
+		s.Hosts.Sub(s.Hosts, big.NewInt(2))
+		s.FirstHost = fromBigInt(new(big.Int).Add(ToBigInt(s.Network), big.NewInt(1)), true)
+		s.LastHost = fromBigInt(new(big.Int).Sub(ToBigInt(s.Broadcast), big.NewInt(1)), true)
+	}
+	return &s, nil
+}
+
+/* Returns ip in dotted (IPv4) or colon (IPv6) binary, with a space at the network/host boundary. */
+func (s *Subnet) Binary(ip net.IP) string {
+	var buf bytes.Buffer
+	groupBits, sep, p := 8, ".", ip.To4()
+	if s.Bits == 128 {
+		groupBits, sep, p = 16, ":", ip.To16()
+	}
+	n := new(big.Int).SetBytes(p)
+	for bit := 0; bit < s.Bits; bit++ {
+		if bit > 0 && bit%groupBits == 0 {
+			buf.WriteString(sep)
+		}
+		if bit == s.Ones && bit > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteByte('0' + byte(n.Bit(s.Bits-1-bit)))
+	}
+	return buf.String()
+}
+
+func (s *Subnet) String() string {
+	var buf bytes.Buffer
+	row := func(name string, ip net.IP) {
+		fmt.Fprintf(&buf, "%-10s %-40s %s\n", name+":", ip, s.Binary(ip))
+	}
+	row("Address", s.Ip)
+	fmt.Fprintf(&buf, "%-10s %-40s %s\n", "Netmask:", fmt.Sprintf("%s = %d", s.Netmask, s.Ones), s.Binary(s.Netmask))
+	row("Wildcard", s.Wildcard)
+	buf.WriteString("=>\n")
+	fmt.Fprintf(&buf, "%-10s %-40s %s\n", "Network:", fmt.Sprintf("%s/%d", s.Network, s.Ones), s.Binary(s.Network))
+	if s.Bits == 32 {
+		row("Broadcast", s.Broadcast)
+	} else {
+		row("Last", s.Broadcast)
+	}
+	row("HostMin", s.FirstHost)
+	row("HostMax", s.LastHost)
+	fmt.Fprintf(&buf, "%-10s %s\n", "Hosts:", s.Hosts)
+	return strings.TrimSuffix(buf.String(), "\n")
+}